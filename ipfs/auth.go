@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	RoleRead   = "read"
+	RoleUpload = "upload"
+	RoleAdmin  = "admin"
+)
+
+var (
+	errNoCredentials      = errors.New("no credentials")
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the authenticated user of a request.
+type Principal struct {
+	User   string
+	Roles  []string
+	Groups []string
+}
+
+// HasRole reports whether the principal was granted role. Admins have
+// every role, and uploaders can also read.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role || r == RoleAdmin || (r == RoleUpload && role == RoleRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// An Authenticator identifies the user behind a request. It returns
+// errNoCredentials when the request carries none it understands, so the
+// next authenticator can be tried.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// bearerToken reads the token from the Authorization header, or from the
+// access_token query parameter for clients that cannot set headers, like
+// browser websockets and download links, or from the password of basic
+// authentication for clients that only speak that, like WebDAV drives.
+func bearerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// TokenAuthenticator accepts static API tokens listed in a file, one per
+// line as "<token> <user> <role>[,<role>...] [<group>[,<group>...]]". Blank
+// lines and lines starting with # are ignored.
+type TokenAuthenticator struct {
+	tokens map[[sha256.Size]byte]*Principal
+}
+
+func NewTokenAuthenticator(path string) (*TokenAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	a := &TokenAuthenticator{tokens: make(map[[sha256.Size]byte]*Principal)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("malformed token line: %q", line)
+		}
+		principal := &Principal{
+			User:  fields[1],
+			Roles: strings.Split(fields[2], ","),
+		}
+		if len(fields) > 3 {
+			principal.Groups = strings.Split(fields[3], ",")
+		}
+		a.tokens[sha256.Sum256([]byte(fields[0]))] = principal
+	}
+	return a, scanner.Err()
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errNoCredentials
+	}
+
+	// compare hashes so that lookups do not leak the tokens through timing
+	sum := sha256.Sum256([]byte(token))
+	for h, p := range a.tokens {
+		if subtle.ConstantTimeCompare(h[:], sum[:]) == 1 {
+			return p, nil
+		}
+	}
+	return nil, errNoCredentials
+}
+
+// JWTAuthenticator accepts JWTs, such as OIDC ID or access tokens, signed
+// by a locally configured issuer key. The user is the sub claim, the roles
+// come from the roles claim, or from the space-separated scope claim, and
+// the groups from the groups claim.
+type JWTAuthenticator struct {
+	key     any
+	methods []string
+	opts    []jwt.ParserOption
+}
+
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Roles  []string `json:"roles"`
+	Scope  string   `json:"scope"`
+	Groups []string `json:"groups"`
+}
+
+// NewJWTAuthenticator loads the issuer key from a PEM public key (RSA,
+// ECDSA or Ed25519) or, failing that, uses the file content as an HMAC
+// secret.
+func NewJWTAuthenticator(keyPath, issuer, audience string) (*JWTAuthenticator, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &JWTAuthenticator{}
+	if block, _ := pem.Decode(data); block != nil {
+		a.key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing issuer key: %w", err)
+		}
+		a.methods = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "ES512", "EdDSA"}
+	} else {
+		a.key = []byte(strings.TrimSpace(string(data)))
+		a.methods = []string{"HS256", "HS384", "HS512"}
+	}
+
+	a.opts = []jwt.ParserOption{jwt.WithValidMethods(a.methods), jwt.WithExpirationRequired()}
+	if issuer != "" {
+		a.opts = append(a.opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		a.opts = append(a.opts, jwt.WithAudience(audience))
+	}
+	return a, nil
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	// JWTs have three dot-separated parts, API tokens do not
+	if strings.Count(token, ".") != 2 {
+		return nil, errNoCredentials
+	}
+
+	var claims jwtClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return a.key, nil
+	}, a.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidCredentials, err)
+	}
+
+	roles := claims.Roles
+	if len(roles) == 0 && claims.Scope != "" {
+		roles = strings.Fields(claims.Scope)
+	}
+	return &Principal{User: claims.Subject, Roles: roles, Groups: claims.Groups}, nil
+}
+
+type principalKey struct{}
+
+// principalFrom returns the user of an authenticated request.
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Without any authenticator configured the API is open and every request
+// is made by an anonymous admin.
+var anonymous = &Principal{User: "anonymous", Roles: []string{RoleAdmin}}
+
+// requireRole wraps a handler so that it only runs for requests whose user
+// has the role.
+func requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ipfs-demo"`)
+			writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Missing or invalid credentials")
+			return
+		}
+		if !principal.HasRole(role) {
+			writeErrorDetails(w, http.StatusForbidden, CodeForbidden, fmt.Sprintf("Role %s required", role),
+				map[string]string{"role": role})
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	}
+}
+
+func authenticate(r *http.Request) (*Principal, error) {
+	if len(authenticators) == 0 {
+		return anonymous, nil
+	}
+	for _, a := range authenticators {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return nil, errNoCredentials
+}
+
+var authenticators []Authenticator
+
+// setUpAuth enables the authenticators configured with flags.
+func setUpAuth() error {
+	if *authTokensFile != "" {
+		a, err := NewTokenAuthenticator(*authTokensFile)
+		if err != nil {
+			return fmt.Errorf("loading API tokens: %w", err)
+		}
+		authenticators = append(authenticators, a)
+	}
+	if *jwtKeyFile != "" {
+		a, err := NewJWTAuthenticator(*jwtKeyFile, *jwtIssuer, *jwtAudience)
+		if err != nil {
+			return fmt.Errorf("loading JWT issuer key: %w", err)
+		}
+		authenticators = append(authenticators, a)
+	}
+	if *s3KeysFile != "" {
+		a, err := NewS3Authenticator(*s3KeysFile)
+		if err != nil {
+			return fmt.Errorf("loading S3 keys: %w", err)
+		}
+		authenticators = append(authenticators, a)
+	}
+	if *tlsClientCAFile != "" {
+		authenticators = append(authenticators, CertAuthenticator{})
+	}
+	return nil
+}
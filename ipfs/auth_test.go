@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrincipalHasRole(t *testing.T) {
+	tests := []struct {
+		roles []string
+		role  string
+		want  bool
+	}{
+		{[]string{RoleRead}, RoleRead, true},
+		{[]string{RoleRead}, RoleUpload, false},
+		{[]string{RoleRead}, RoleAdmin, false},
+		{[]string{RoleUpload}, RoleRead, true},
+		{[]string{RoleUpload}, RoleUpload, true},
+		{[]string{RoleUpload}, RoleAdmin, false},
+		{[]string{RoleAdmin}, RoleRead, true},
+		{[]string{RoleAdmin}, RoleUpload, true},
+		{[]string{RoleAdmin}, RoleAdmin, true},
+		{[]string{"other", RoleUpload}, RoleUpload, true},
+		{[]string{"other"}, RoleRead, false},
+		{nil, RoleRead, false},
+	}
+	for _, tt := range tests {
+		p := &Principal{User: "u", Roles: tt.roles}
+		if got := p.HasRole(tt.role); got != tt.want {
+			t.Errorf("roles %v: HasRole(%s) = %v, want %v", tt.roles, tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	path := writeTestFile(t, "tokens", `
+# API tokens
+reader-token alice read
+writer-token bob upload,read team,ops
+`)
+	a, err := NewTokenAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		set    func(r *http.Request)
+		user   string
+		groups int
+		err    error
+	}{
+		{"bearer header", func(r *http.Request) { r.Header.Set("Authorization", "Bearer reader-token") }, "alice", 0, nil},
+		{"basic password", func(r *http.Request) { r.SetBasicAuth("ignored", "writer-token") }, "bob", 2, nil},
+		{"query parameter", func(r *http.Request) { r.URL.RawQuery = "access_token=writer-token" }, "bob", 2, nil},
+		{"unknown token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, "", 0, errNoCredentials},
+		{"no token", func(r *http.Request) {}, "", 0, errNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/files", nil)
+			tt.set(r)
+			p, err := a.Authenticate(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if p.User != tt.user || len(p.Groups) != tt.groups {
+				t.Errorf("principal = %+v, want user %s with %d groups", p, tt.user, tt.groups)
+			}
+		})
+	}
+
+	if _, err := NewTokenAuthenticator(writeTestFile(t, "bad", "token-without-role alice\n")); err == nil {
+		t.Error("malformed token line accepted")
+	}
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	secret := []byte("jwt-test-secret")
+	hmacAuth, err := NewJWTAuthenticator(writeTestFile(t, "secret", string(secret)+"\n"), "issuer", "ipfs-demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	edAuth, err := NewJWTAuthenticator(writeTestFile(t, "key.pem", string(pemKey)), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": "carol", "iss": "issuer", "aud": "ipfs-demo", "exp": exp}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	hs256 := func(extra jwt.MapClaims) string { return signJWT(t, jwt.SigningMethodHS256, secret, claims(extra)) }
+
+	tests := []struct {
+		name  string
+		auth  *JWTAuthenticator
+		token string
+		roles []string
+		err   error
+	}{
+		{"roles claim", hmacAuth, hs256(jwt.MapClaims{"roles": []string{"upload"}, "scope": "admin"}), []string{"upload"}, nil},
+		{"scope claim", hmacAuth, hs256(jwt.MapClaims{"scope": "read upload"}), []string{"read", "upload"}, nil},
+		{"no roles", hmacAuth, hs256(nil), nil, nil},
+		{"expired", hmacAuth, hs256(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), nil, errInvalidCredentials},
+		{"no expiry", hmacAuth, hs256(jwt.MapClaims{"exp": nil}), nil, errInvalidCredentials},
+		{"wrong issuer", hmacAuth, hs256(jwt.MapClaims{"iss": "other"}), nil, errInvalidCredentials},
+		{"wrong audience", hmacAuth, hs256(jwt.MapClaims{"aud": "other"}), nil, errInvalidCredentials},
+		{"wrong secret", hmacAuth, signJWT(t, jwt.SigningMethodHS256, []byte("other"), claims(nil)), nil, errInvalidCredentials},
+		{"unsigned", hmacAuth, signJWT(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(nil)), nil, errInvalidCredentials},
+		{"not a JWT", hmacAuth, "api-token", nil, errNoCredentials},
+		{"public key", edAuth, signJWT(t, jwt.SigningMethodEdDSA, priv, claims(jwt.MapClaims{"roles": []string{"read"}})), []string{"read"}, nil},
+		{"HMAC with public key", edAuth, signJWT(t, jwt.SigningMethodHS256, der, claims(nil)), nil, errInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/files", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			p, err := tt.auth.Authenticate(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if p.User != "carol" {
+				t.Errorf("user = %s, want carol", p.User)
+			}
+			if len(p.Roles) != len(tt.roles) {
+				t.Fatalf("roles = %v, want %v", p.Roles, tt.roles)
+			}
+			for i := range tt.roles {
+				if p.Roles[i] != tt.roles[i] {
+					t.Errorf("roles = %v, want %v", p.Roles, tt.roles)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	a, err := NewTokenAuthenticator(writeTestFile(t, "tokens", "r rita read\nu ugo upload\na ada admin\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticators = []Authenticator{a}
+	t.Cleanup(func() { authenticators = nil })
+
+	tests := []struct {
+		token string
+		role  string
+		want  int
+	}{
+		{"", RoleRead, http.StatusUnauthorized},
+		{"bad", RoleRead, http.StatusUnauthorized},
+		{"r", RoleRead, http.StatusOK},
+		{"r", RoleUpload, http.StatusForbidden},
+		{"u", RoleRead, http.StatusOK},
+		{"u", RoleUpload, http.StatusOK},
+		{"u", RoleAdmin, http.StatusForbidden},
+		{"a", RoleAdmin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		var user string
+		h := requireRole(tt.role, func(w http.ResponseWriter, r *http.Request) {
+			user = principalFrom(r.Context()).User
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/files", nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		h(w, r)
+		if w.Code != tt.want {
+			t.Errorf("token %q for %s: status = %d, want %d", tt.token, tt.role, w.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("token %q: no WWW-Authenticate challenge", tt.token)
+		}
+		if (tt.want == http.StatusOK) != (user != "") {
+			t.Errorf("token %q for %s: handler ran = %v", tt.token, tt.role, user != "")
+		}
+	}
+
+	// without authenticators every request is an anonymous admin
+	authenticators = nil
+	w := httptest.NewRecorder()
+	requireRole(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {})(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("open API: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
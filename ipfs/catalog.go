@@ -0,0 +1,178 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ipfslite "ipfs-demo/ipfs"
+
+	"github.com/ipfs/go-datastore"
+)
+
+type FileInfo struct {
+	Filename   string    `json:"filename"`
+	CID        string    `json:"cid"`
+	Size       int64     `json:"size"`
+	Type       string    `json:"type"`
+	Origin     string    `json:"origin,omitempty"` // ID of the peer the file was uploaded to
+	Owner      string    `json:"owner,omitempty"`  // user who uploaded the file
+	Groups     []string  `json:"groups,omitempty"` // groups of the owner, charged for the file
+	Encrypted  bool      `json:"encrypted,omitempty"`
+	ETag       string    `json:"etag,omitempty"` // of S3 objects, quoted
+	UploadedAt time.Time `json:"uploadedAt"`
+
+	// Uploads of the same filename are versions of one file, numbered from 1
+	Version      int `json:"version,omitempty"`
+	RestoredFrom int `json:"restoredFrom,omitempty"` // version this one was restored from
+
+	// Editable with PATCH /files/{cid}/metadata
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// FileEvent tells websocket clients about the removal of a file. New and
+// edited files are sent as their FileInfo.
+type FileEvent struct {
+	Event string `json:"event"`
+	CID   string `json:"cid"`
+}
+
+const FileDeleted = "deleted"
+
+// Catalog is the list of known files, shared by every node of the cluster
+// through a CRDT store. Entries are keyed by CID, version and filename, so
+// the same content can be several versions of a file, or be uploaded under
+// several names.
+type Catalog struct {
+	store *ipfslite.CRDT
+}
+
+func NewCatalog(store *ipfslite.CRDT) *Catalog {
+	return &Catalog{store: store}
+}
+
+// entryKey returns the key of the catalog entry of a file version. It
+// starts with the CID, which has no slash.
+func entryKey(fileInfo FileInfo) string {
+	return fileInfo.CID + "/" + strconv.Itoa(fileInfo.Version) + "/" + fileInfo.Filename
+}
+
+// keyCID returns the CID of the entry of a key.
+func keyCID(key string) string {
+	c, _, _ := strings.Cut(key, "/")
+	return c
+}
+
+// Add records a file version in the catalog. It returns false without
+// error when this version of the filename is already recorded with the
+// same content.
+func (c *Catalog) Add(ctx context.Context, fileInfo FileInfo) (bool, error) {
+	key := entryKey(fileInfo)
+	if _, err := c.store.Get(ctx, key); err == nil {
+		return false, nil
+	} else if !errors.Is(err, datastore.ErrNotFound) {
+		return false, err
+	}
+
+	data, err := json.Marshal(fileInfo)
+	if err != nil {
+		return false, err
+	}
+	return true, c.store.Put(ctx, key, data)
+}
+
+// Update replaces the catalog entry of a file version.
+func (c *Catalog) Update(ctx context.Context, fileInfo FileInfo) error {
+	data, err := json.Marshal(fileInfo)
+	if err != nil {
+		return err
+	}
+	return c.store.Put(ctx, entryKey(fileInfo), data)
+}
+
+// Remove deletes the catalog entry of a file version. It returns
+// datastore.ErrNotFound when there is none.
+func (c *Catalog) Remove(ctx context.Context, fileInfo FileInfo) error {
+	return c.store.Delete(ctx, entryKey(fileInfo))
+}
+
+// Entries returns the entries with the content of a CID, latest upload
+// first.
+func (c *Catalog) Entries(ctx context.Context, cid string) ([]FileInfo, error) {
+	fileInfos, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := slices.DeleteFunc(fileInfos, func(fileInfo FileInfo) bool { return fileInfo.CID != cid })
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// Get returns the latest uploaded entry of a CID, or datastore.ErrNotFound.
+func (c *Catalog) Get(ctx context.Context, cid string) (FileInfo, error) {
+	entries, err := c.Entries(ctx, cid)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if len(entries) == 0 {
+		return FileInfo{}, datastore.ErrNotFound
+	}
+	return entries[0], nil
+}
+
+// List returns all catalog entries, oldest upload first.
+func (c *Catalog) List(ctx context.Context) ([]FileInfo, error) {
+	values, err := c.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfos := make([]FileInfo, 0, len(values))
+	for _, data := range values {
+		var fileInfo FileInfo
+		if err := json.Unmarshal(data, &fileInfo); err != nil {
+			continue // Skip malformed entries
+		}
+		fileInfos = append(fileInfos, fileInfo)
+	}
+
+	slices.SortFunc(fileInfos, func(a, b FileInfo) int {
+		return cmp.Or(a.UploadedAt.Compare(b.UploadedAt), cmp.Compare(a.CID, b.CID),
+			cmp.Compare(a.Filename, b.Filename), cmp.Compare(a.Version, b.Version))
+	})
+	return fileInfos, nil
+}
+
+// compareVersions orders the versions of a file, oldest first.
+func compareVersions(a, b FileInfo) int {
+	return cmp.Or(cmp.Compare(a.Version, b.Version), a.UploadedAt.Compare(b.UploadedAt), cmp.Compare(a.CID, b.CID))
+}
+
+// latestVersions returns the latest version of each filename.
+func latestVersions(fileInfos []FileInfo) map[string]FileInfo {
+	latest := make(map[string]FileInfo)
+	for _, fileInfo := range fileInfos {
+		if l, ok := latest[fileInfo.Filename]; !ok || compareVersions(fileInfo, l) > 0 {
+			latest[fileInfo.Filename] = fileInfo
+		}
+	}
+	return latest
+}
+
+// Versions returns the entries of a filename, oldest version first.
+func (c *Catalog) Versions(ctx context.Context, filename string) ([]FileInfo, error) {
+	fileInfos, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := slices.DeleteFunc(fileInfos, func(fileInfo FileInfo) bool { return fileInfo.Filename != filename })
+	slices.SortFunc(versions, compareVersions)
+	return versions, nil
+}
@@ -0,0 +1,168 @@
+// Package client is a Go client of the HTTP API of ipfs-demo nodes.
+//
+//	c, err := client.New("http://localhost:8000", &client.Options{Token: token})
+//	fileInfo, err := c.Upload(ctx, file, client.UploadRequest{Filename: "report.pdf"})
+//	download, err := c.Download(ctx, fileInfo.CID, nil)
+//
+// Methods take a context, which bounds the whole request, including the
+// reading of downloads. Error responses of the API are returned as
+// *APIError.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Options configures a Client.
+type Options struct {
+	// Token is the API token or JWT sent as a bearer token, for nodes that
+	// require authentication.
+	Token string
+	// HTTPClient sends the requests, http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// Dialer opens the websockets of subscriptions, websocket.DefaultDialer
+	// when nil.
+	Dialer *websocket.Dialer
+}
+
+// Client calls the API of one node. It is safe for concurrent use.
+type Client struct {
+	base *url.URL
+	opts Options
+}
+
+// New returns a client of the node whose API is at baseURL, e.g.
+// http://localhost:8000.
+func New(baseURL string, opts *Options) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid API URL %q: expected an http or https URL", baseURL)
+	}
+	c := &Client{base: base}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.HTTPClient == nil {
+		c.opts.HTTPClient = http.DefaultClient
+	}
+	if c.opts.Dialer == nil {
+		c.opts.Dialer = websocket.DefaultDialer
+	}
+	return c, nil
+}
+
+// Codes of APIError, as sent by the node.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeInvalidCID       = "invalid_cid"
+	CodeInvalidParameter = "invalid_parameter"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeNotAcceptable    = "not_acceptable"
+	CodeConflict         = "conflict"
+	CodeGone             = "gone"
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeUnprocessable    = "unprocessable"
+	CodeRateLimited      = "rate_limited"
+	CodeTimeout          = "timeout"
+	CodeInternal         = "internal"
+)
+
+// APIError is an error response of the API.
+type APIError struct {
+	StatusCode int             `json:"-"`
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, e.Code)
+	if len(e.Details) > 0 {
+		msg += ": " + string(e.Details)
+	}
+	return msg
+}
+
+// url returns the URL of an API path, with query parameters.
+func (c *Client) url(path string, query url.Values) *url.URL {
+	u := *c.base
+	u.Path += path
+	u.RawPath = ""
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return &u
+}
+
+// newRequest makes an authenticated request to the API.
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req.Header)
+	return req, nil
+}
+
+func (c *Client) authorize(header http.Header) {
+	if c.opts.Token != "" {
+		header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+}
+
+// do sends a request, turning error responses into APIErrors.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, readAPIError(resp)
+}
+
+// readAPIError reads the error of a response. Responses that are not
+// errors of the API, e.g. from a proxy, are described by their status.
+func readAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(data, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Code = http.StatusText(resp.StatusCode)
+		apiErr.Message = strings.TrimSpace(string(data))
+		apiErr.Details = nil
+	}
+	return apiErr
+}
+
+// call sends a request without a body and decodes its JSON response into
+// v, unless v is nil.
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, v any) error {
+	req, err := c.newRequest(ctx, method, path, query, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
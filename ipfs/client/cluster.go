@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Pins lists the pins of the cluster.
+func (c *Client) Pins(ctx context.Context) ([]PinInfo, error) {
+	var infos []PinInfo
+	err := c.call(ctx, http.MethodGet, "/pins", nil, &infos)
+	return infos, err
+}
+
+// Pin returns the pin of a CID and its state on the peers.
+func (c *Client) Pin(ctx context.Context, cid string) (PinInfo, error) {
+	var info PinInfo
+	err := c.call(ctx, http.MethodGet, "/pins/"+cid, nil, &info)
+	return info, err
+}
+
+// PinOptions configures a new pin. Zero fields take the defaults of the
+// node.
+type PinOptions struct {
+	Name           string
+	ReplicationMin int
+	ReplicationMax int
+}
+
+// AddPin asks the cluster to pin a CID, which needs the admin role. The
+// pinning happens in the background; Pin tells its progress.
+func (c *Client) AddPin(ctx context.Context, cid string, opts *PinOptions) (PinIntent, error) {
+	query := url.Values{}
+	if opts != nil {
+		if opts.Name != "" {
+			query.Set("name", opts.Name)
+		}
+		if opts.ReplicationMin > 0 {
+			query.Set("replication-min", strconv.Itoa(opts.ReplicationMin))
+		}
+		if opts.ReplicationMax > 0 {
+			query.Set("replication-max", strconv.Itoa(opts.ReplicationMax))
+		}
+	}
+	var intent PinIntent
+	err := c.call(ctx, http.MethodPost, "/pins/"+cid, query, &intent)
+	return intent, err
+}
+
+// RemovePin unpins a CID across the cluster, which needs the admin role.
+func (c *Client) RemovePin(ctx context.Context, cid string) error {
+	return c.call(ctx, http.MethodDelete, "/pins/"+cid, nil, nil)
+}
+
+// Peers lists the peers the node is connected to, only the members of its
+// cluster with clusterOnly.
+func (c *Client) Peers(ctx context.Context, clusterOnly bool) ([]PeerInfo, error) {
+	var peers []PeerInfo
+	err := c.call(ctx, http.MethodGet, "/peers", url.Values{"cluster": {strconv.FormatBool(clusterOnly)}}, &peers)
+	return peers, err
+}
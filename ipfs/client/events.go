@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Types of Event.
+const (
+	// EventFile is a file added to the catalog, or whose entry changed.
+	EventFile = "file"
+	// EventDeleted is a file removed from the catalog.
+	EventDeleted = "deleted"
+	// EventConnected is sent on every connection of a subscription.
+	EventConnected = "connected"
+	// EventDisconnected is sent when the connection is lost or cannot be
+	// made again, before retrying.
+	EventDisconnected = "disconnected"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+	eventBuffer       = 64
+)
+
+// Event is an event of a subscription.
+type Event struct {
+	Type string
+	CID  string   // of EventFile and EventDeleted
+	File FileInfo // of EventFile
+	Err  error    // of EventDisconnected, why the connection was lost
+
+	// Raw is the message of EventFile and EventDeleted as the node sent it.
+	Raw json.RawMessage
+}
+
+// Subscribe follows the changes of the catalog of the cluster: files
+// added or edited on any node, and files deleted. Events are sent on the
+// returned channel, which is closed when ctx is done.
+//
+// When the connection is lost the subscription reconnects, waiting from 1
+// to 30 seconds between tries. It sends EventDisconnected for each failure
+// and EventConnected once connected; changes in between are missed, so
+// clients that mirror the catalog should list it again then. Failing to
+// authenticate ends the subscription.
+//
+// The first connection is made before Subscribe returns, and its error
+// returned.
+func (c *Client) Subscribe(ctx context.Context) (<-chan Event, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan Event, eventBuffer)
+	go c.subscribe(ctx, conn, events)
+	return events, nil
+}
+
+func (c *Client) subscribe(ctx context.Context, conn *websocket.Conn, events chan<- Event) {
+	defer close(events)
+	send := func(event Event) bool {
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		if !send(Event{Type: EventConnected}) {
+			conn.Close()
+			return
+		}
+		err := c.read(ctx, conn, send)
+
+		delay := minReconnectDelay
+		for {
+			if ctx.Err() != nil || !send(Event{Type: EventDisconnected, Err: err}) {
+				return
+			}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(2*delay, maxReconnectDelay)
+
+			if conn, err = c.dial(ctx); err == nil {
+				break
+			}
+		}
+	}
+}
+
+// read sends the events received on conn until it is lost or ctx is done.
+func (c *Client) read(ctx context.Context, conn *websocket.Conn, send func(Event) bool) error {
+	defer conn.Close()
+
+	// unblock the read when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		event, err := parseEvent(data)
+		if err != nil {
+			continue // not an event of this version of the client
+		}
+		if !send(event) {
+			return ctx.Err()
+		}
+	}
+}
+
+// parseEvent reads a message of /socket: the FileInfo of a file, or an
+// event about a CID.
+func parseEvent(data []byte) (Event, error) {
+	var probe struct {
+		Event string `json:"event"`
+		CID   string `json:"cid"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return Event{}, err
+	}
+	event := Event{Type: probe.Event, CID: probe.CID, Raw: data}
+	if probe.Event == "" {
+		event.Type = EventFile
+		if err := json.Unmarshal(data, &event.File); err != nil {
+			return Event{}, err
+		}
+	}
+	return event, nil
+}
+
+// dial opens the websocket of the events of the node.
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	u := c.url("/socket", nil)
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	header := http.Header{}
+	c.authorize(header)
+	conn, resp, err := c.opts.Dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			return nil, readAPIError(resp)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UploadRequest describes a file to upload.
+type UploadRequest struct {
+	Filename string
+	// Type is the MIME type of the file, guessed from the extension of the
+	// filename when empty.
+	Type        string
+	Description string
+	Tags        []string
+	// Encrypt encrypts the file with a key kept by the node.
+	Encrypt bool
+}
+
+// Upload streams the content of r to the node as a file, which becomes
+// the next version of its filename, and returns its catalog entry.
+func (c *Client) Upload(ctx context.Context, r io.Reader, upload UploadRequest) (FileInfo, error) {
+	if upload.Filename == "" {
+		return FileInfo{}, fmt.Errorf("upload without a filename")
+	}
+	body, contentType := multipartBody(upload, r)
+	req, err := c.newRequest(ctx, http.MethodPost, "/upload", nil, body)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.do(req)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	var fileInfos []FileInfo
+	if err := json.NewDecoder(resp.Body).Decode(&fileInfos); err != nil {
+		return FileInfo{}, err
+	}
+	if len(fileInfos) != 1 {
+		return FileInfo{}, fmt.Errorf("expected 1 file in the response, got %d", len(fileInfos))
+	}
+	return fileInfos[0], nil
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// multipartBody streams the form of an upload, so that large files are
+// never held in memory.
+func multipartBody(upload UploadRequest, content io.Reader) (io.Reader, string) {
+	typ := upload.Type
+	if typ == "" {
+		typ = mime.TypeByExtension(filepath.Ext(upload.Filename))
+	}
+	if typ == "" {
+		typ = "application/octet-stream"
+	}
+	fields := map[string]string{
+		"description": upload.Description,
+		"tags":        strings.Join(upload.Tags, ","),
+		"encrypt":     strconv.FormatBool(upload.Encrypt),
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(func() error {
+			for name, value := range fields {
+				if err := mw.WriteField(name, value); err != nil {
+					return err
+				}
+			}
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="files"; filename="%s"`, quoteEscaper.Replace(upload.Filename)))
+			header.Set("Content-Type", typ)
+			part, err := mw.CreatePart(header)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(part, content); err != nil {
+				return err
+			}
+			return mw.Close()
+		}())
+	}()
+	return pr, mw.FormDataContentType()
+}
+
+// Sort orders of ListQuery.
+const (
+	SortUploadedAt = "uploadedAt"
+	SortFilename   = "filename"
+	SortSize       = "size"
+)
+
+// ListQuery selects, orders and pages catalog entries. Zero fields do not
+// filter.
+type ListQuery struct {
+	Text    string   // terms that must all appear in the filename, type, description, tags or metadata
+	Name    string   // substring of the filename
+	Type    string   // MIME type, or a range such as image/*
+	Owner   string   // user who uploaded the files
+	Tags    []string // tags the files must all have
+	SizeMin int64    // in bytes, when > 0
+	SizeMax int64    // in bytes, when > 0
+	After   time.Time
+	Before  time.Time
+
+	AllVersions bool // include the older versions of files, not only the latest
+
+	Sort   string // SortUploadedAt by default
+	Desc   bool
+	Limit  int    // entries per page, 100 by default and at most 1000
+	Cursor string // NextCursor of the previous page
+}
+
+func (q ListQuery) values() url.Values {
+	values := url.Values{}
+	for name, value := range map[string]string{"q": q.Text, "name": q.Name, "type": q.Type, "owner": q.Owner, "sort": q.Sort, "cursor": q.Cursor} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	if len(q.Tags) > 0 {
+		values.Set("tag", strings.Join(q.Tags, ","))
+	}
+	if q.SizeMin > 0 {
+		values.Set("size-min", strconv.FormatInt(q.SizeMin, 10))
+	}
+	if q.SizeMax > 0 {
+		values.Set("size-max", strconv.FormatInt(q.SizeMax, 10))
+	}
+	if !q.After.IsZero() {
+		values.Set("uploaded-after", q.After.Format(time.RFC3339))
+	}
+	if !q.Before.IsZero() {
+		values.Set("uploaded-before", q.Before.Format(time.RFC3339))
+	}
+	if q.AllVersions {
+		values.Set("versions", "all")
+	}
+	if q.Desc {
+		values.Set("order", "desc")
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return values
+}
+
+// List returns a page of the catalog entries matching q. The next page is
+// listed with the NextCursor of the page as the Cursor of q, until it is
+// empty.
+func (c *Client) List(ctx context.Context, q ListQuery) (FilePage, error) {
+	var page FilePage
+	err := c.call(ctx, http.MethodGet, "/files", q.values(), &page)
+	return page, err
+}
+
+// DownloadOptions selects the part of a file to download.
+type DownloadOptions struct {
+	Offset int64 // first byte to read
+	Length int64 // number of bytes to read from Offset, to the end when 0
+	// Timeout is how long the node looks for the content in the network,
+	// its default when 0.
+	Timeout time.Duration
+}
+
+// Download is the content of a file being downloaded. It must be closed.
+type Download struct {
+	io.ReadCloser
+	Filename string // name in the catalog, or the CID
+	Type     string
+	Size     int64 // of the whole file, -1 when unknown
+	Offset   int64 // of the first byte of the content
+	Length   int64 // of the content, -1 when unknown
+}
+
+// Download opens the content of a file. With opts, only a range of it is
+// downloaded.
+func (c *Client) Download(ctx context.Context, cid string, opts *DownloadOptions) (*Download, error) {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	if opts.Offset < 0 || opts.Length < 0 {
+		return nil, fmt.Errorf("invalid range: offset %d, length %d", opts.Offset, opts.Length)
+	}
+	query := url.Values{}
+	if opts.Timeout > 0 {
+		query.Set("timeout", opts.Timeout.String())
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, "/files/"+cid, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	ranged := opts.Offset > 0 || opts.Length > 0
+	if ranged {
+		r := fmt.Sprintf("bytes=%d-", opts.Offset)
+		if opts.Length > 0 {
+			r += strconv.FormatInt(opts.Offset+opts.Length-1, 10)
+		}
+		req.Header.Set("Range", r)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Download{
+		ReadCloser: resp.Body,
+		Filename:   cid,
+		Type:       resp.Header.Get("Content-Type"),
+		Size:       -1,
+		Length:     resp.ContentLength,
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		d.Filename = params["filename"]
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		var first, last int64
+		var size string
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%s", &first, &last, &size); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("invalid Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		d.Offset = first
+		if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+			d.Size = n
+		}
+	case ranged:
+		// the whole file was sent anyway
+		resp.Body.Close()
+		return nil, fmt.Errorf("the node did not serve the range, status %s", resp.Status)
+	default:
+		d.Size = resp.ContentLength
+	}
+	return d, nil
+}
+
+// Delete removes a file from the catalog and unpins it across the
+// cluster. With gc, the blocks that no other file uses are also deleted
+// from the node right away.
+func (c *Client) Delete(ctx context.Context, cid string, gc bool) (DeleteResult, error) {
+	var result DeleteResult
+	err := c.call(ctx, http.MethodDelete, "/files/"+cid, url.Values{"gc": {strconv.FormatBool(gc)}}, &result)
+	return result, err
+}
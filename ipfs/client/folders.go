@@ -0,0 +1,24 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Mkdir creates a folder of the folder tree of the node, and with parents
+// the folders above it.
+func (c *Client) Mkdir(ctx context.Context, path string, parents bool) (FolderRoot, error) {
+	var root FolderRoot
+	err := c.call(ctx, http.MethodPost, "/folders/mkdir", url.Values{"path": {path}, "parents": {strconv.FormatBool(parents)}}, &root)
+	return root, err
+}
+
+// Cp copies a file or folder of the folder tree, or content given as
+// /ipfs/<cid> such as an upload, to the path to.
+func (c *Client) Cp(ctx context.Context, from, to string) (FolderRoot, error) {
+	var root FolderRoot
+	err := c.call(ctx, http.MethodPost, "/folders/cp", url.Values{"from": {from}, "to": {to}}, &root)
+	return root, err
+}
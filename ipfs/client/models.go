@@ -0,0 +1,99 @@
+package client
+
+import "time"
+
+// FileInfo is the catalog entry of an uploaded file.
+type FileInfo struct {
+	Filename   string    `json:"filename"`
+	CID        string    `json:"cid"`
+	Size       int64     `json:"size"`
+	Type       string    `json:"type"`
+	Origin     string    `json:"origin,omitempty"` // ID of the peer the file was uploaded to
+	Owner      string    `json:"owner,omitempty"`  // user who uploaded the file
+	Groups     []string  `json:"groups,omitempty"` // groups of the owner, charged for the file
+	Encrypted  bool      `json:"encrypted,omitempty"`
+	ETag       string    `json:"etag,omitempty"` // of S3 objects, quoted
+	UploadedAt time.Time `json:"uploadedAt"`
+
+	// Uploads of the same filename are versions of one file, numbered from 1
+	Version      int `json:"version,omitempty"`
+	RestoredFrom int `json:"restoredFrom,omitempty"` // version this one was restored from
+
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// FilePage is a page of catalog entries.
+type FilePage struct {
+	Files      []FileInfo `json:"files"`
+	Total      int        `json:"total"` // number of entries matching the query, on all pages
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// DeleteResult is the result of deleting a file.
+type DeleteResult struct {
+	CID           string `json:"cid"`
+	BlocksRemoved int    `json:"blocksRemoved"` // with garbage collection
+}
+
+// Statuses of PinStatus.
+const (
+	PinStatusPinning = "pinning"
+	PinStatusPinned  = "pinned"
+	PinStatusFailed  = "failed"
+)
+
+// PinIntent asks the cluster to keep a CID pinned on between
+// ReplicationMin and ReplicationMax nodes. Allocations lists the peers
+// that should pin it.
+type PinIntent struct {
+	CID            string    `json:"cid"`
+	Name           string    `json:"name,omitempty"`
+	ReplicationMin int       `json:"replicationMin"`
+	ReplicationMax int       `json:"replicationMax"`
+	Allocations    []string  `json:"allocations"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// PinStatus is the state of a pin on one peer.
+type PinStatus struct {
+	Peer      string    `json:"peer"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// PinInfo is a pin intent and its state on the peers.
+type PinInfo struct {
+	PinIntent
+	Peers []PinStatus `json:"peers"`
+	// Replicas counts the allocated peers that have pinned the CID or are
+	// pinning it. The pin is under-replicated below ReplicationMin.
+	Replicas        int  `json:"replicas"`
+	UnderReplicated bool `json:"underReplicated"`
+}
+
+// Pinned is the number of peers that have pinned the CID.
+func (p PinInfo) Pinned() int {
+	n := 0
+	for _, status := range p.Peers {
+		if status.Status == PinStatusPinned {
+			n++
+		}
+	}
+	return n
+}
+
+// PeerInfo is a peer a node is connected to.
+type PeerInfo struct {
+	ID      string   `json:"id"`
+	Addrs   []string `json:"addrs"`
+	Cluster bool     `json:"cluster,omitempty"` // member of the cluster of the node
+}
+
+// FolderRoot is the state of the folder tree of a node.
+type FolderRoot struct {
+	CID  string `json:"cid"`
+	IPNS string `json:"ipns,omitempty"` // name the root is published under
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"ipfs-demo/client"
+)
+
+func pinCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return &usageError{"Expected ls, status, add or rm"}
+	}
+	sub, args := args[0], args[1:]
+	flags := newFlagSet("pin")
+	replicationMin := flags.Int("min", 0, "minimum number of nodes to pin on, the default of the node when 0")
+	replicationMax := flags.Int("max", 0, "maximum number of nodes to pin on, the default of the node when 0")
+	name := flags.String("name", "", "name of the pin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "ls":
+		if flags.NArg() != 0 {
+			return &usageError{"Unexpected arguments"}
+		}
+		infos, err := c.Pins(ctx)
+		if err != nil {
+			return err
+		}
+		if *jsonOutput {
+			for _, info := range infos {
+				printJSON(info)
+			}
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CID\tREPLICATION\tPINNED\tNAME")
+		for _, info := range infos {
+			fmt.Fprintf(tw, "%s\t%d-%d\t%d/%d\t%s\n", info.CID, info.ReplicationMin, info.ReplicationMax,
+				info.Pinned(), len(info.Allocations), info.Name)
+		}
+		return tw.Flush()
+
+	case "status":
+		if flags.NArg() != 1 {
+			return &usageError{"Expected one CID"}
+		}
+		info, err := c.Pin(ctx, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		if *jsonOutput {
+			return printJSON(info)
+		}
+		fmt.Printf("%s %s, replication %d-%d, allocated to %s\n", info.CID, info.Name,
+			info.ReplicationMin, info.ReplicationMax, strings.Join(info.Allocations, ", "))
+		if info.UnderReplicated {
+			fmt.Printf("under-replicated: %d of at least %d replicas\n", info.Replicas, info.ReplicationMin)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PEER\tSTATUS\tUPDATED\tERROR")
+		for _, status := range info.Peers {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", status.Peer, status.Status, formatTime(status.UpdatedAt), status.Error)
+		}
+		return tw.Flush()
+
+	case "add":
+		if flags.NArg() != 1 {
+			return &usageError{"Expected one CID"}
+		}
+		intent, err := c.AddPin(ctx, flags.Arg(0), &client.PinOptions{
+			Name:           *name,
+			ReplicationMin: *replicationMin,
+			ReplicationMax: *replicationMax,
+		})
+		if err != nil {
+			return err
+		}
+		if *jsonOutput {
+			return printJSON(intent)
+		}
+		fmt.Printf("pinning %s on %s\n", intent.CID, strings.Join(intent.Allocations, ", "))
+		return nil
+
+	case "rm":
+		if flags.NArg() != 1 {
+			return &usageError{"Expected one CID"}
+		}
+		if err := c.RemovePin(ctx, flags.Arg(0)); err != nil {
+			return err
+		}
+		if !*jsonOutput {
+			fmt.Printf("unpinned %s\n", flags.Arg(0))
+		}
+		return nil
+	}
+	return &usageError{fmt.Sprintf("Unknown pin command %q", sub)}
+}
+
+func peersCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("peers")
+	cluster := flags.Bool("cluster", false, "list only the members of the cluster of the node")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return &usageError{"Unexpected arguments"}
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	peers, err := c.Peers(ctx, *cluster)
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		for _, p := range peers {
+			printJSON(p)
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tCLUSTER\tADDRESSES")
+	for _, p := range peers {
+		member := ""
+		if p.Cluster {
+			member = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.ID, member, strings.Join(p.Addrs, " "))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"ipfs-demo/client"
+)
+
+const maxPageSize = 1000
+
+// upload is a file to add.
+type upload struct {
+	path string // on disk
+	rel  string // slash-separated path under -folder
+	size int64
+}
+
+func addCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("add")
+	recursive := flags.Bool("r", false, "add the files of directories, recursively")
+	encrypt := flags.Bool("encrypt", false, "encrypt the files, with keys kept by the node")
+	description := flags.String("description", "", "description of the files")
+	tags := flags.String("tags", "", "comma-separated tags of the files")
+	folder := flags.String("folder", "", "also add the files to this folder of the folder tree, keeping the layout of directories")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return &usageError{"No files to add"}
+	}
+	if *folder != "" && !strings.HasPrefix(*folder, "/") {
+		return &usageError{"-folder must be an absolute path"}
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	uploads, err := collectUploads(flags.Args(), *recursive)
+	if err != nil {
+		return err
+	}
+	request := client.UploadRequest{Description: *description, Encrypt: *encrypt}
+	if *tags != "" {
+		request.Tags = strings.Split(*tags, ",")
+	}
+	for _, u := range uploads {
+		fileInfo, err := uploadFile(ctx, c, u, request)
+		if err != nil {
+			return fmt.Errorf("adding %s: %w", u.path, err)
+		}
+		if *folder != "" {
+			if err := addToFolder(ctx, c, fileInfo.CID, path.Join(*folder, u.rel)); err != nil {
+				return fmt.Errorf("adding %s to %s: %w", u.path, *folder, err)
+			}
+		}
+		if *jsonOutput {
+			printJSON(fileInfo)
+		} else {
+			fmt.Printf("added %s %s\n", fileInfo.CID, u.path)
+		}
+	}
+	return nil
+}
+
+// collectUploads lists the files to add for the arguments of add. The
+// regular files of directories are added with -r, under the name of the
+// directory.
+func collectUploads(args []string, recursive bool) ([]upload, error) {
+	var uploads []upload
+	for _, arg := range args {
+		stat, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !stat.IsDir() {
+			uploads = append(uploads, upload{path: arg, rel: filepath.Base(arg), size: stat.Size()})
+			continue
+		}
+		if !recursive {
+			return nil, fmt.Errorf("%s is a directory, add it with -r", arg)
+		}
+
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, err
+		}
+		base := filepath.Base(abs)
+		err = filepath.WalkDir(arg, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// skip directories themselves, links and special files
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(arg, p)
+			if err != nil {
+				return err
+			}
+			uploads = append(uploads, upload{path: p, rel: path.Join(base, filepath.ToSlash(rel)), size: info.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return uploads, nil
+}
+
+// uploadFile adds one file, with a progress bar.
+func uploadFile(ctx context.Context, c *client.Client, u upload, request client.UploadRequest) (client.FileInfo, error) {
+	file, err := os.Open(u.path)
+	if err != nil {
+		return client.FileInfo{}, err
+	}
+	defer file.Close()
+
+	request.Filename = u.rel
+	bar := newProgressBar(u.rel, u.size)
+	fileInfo, err := c.Upload(ctx, &progressReader{r: file, bar: bar}, request)
+	bar.Finish()
+	return fileInfo, err
+}
+
+// addToFolder links an upload into the folder tree at target, creating the
+// folders above it.
+func addToFolder(ctx context.Context, c *client.Client, cid, target string) error {
+	if _, err := c.Mkdir(ctx, path.Dir(target), true); err != nil {
+		return err
+	}
+	_, err := c.Cp(ctx, "/ipfs/"+cid, target)
+	return err
+}
+
+type downloadResult struct {
+	CID  string `json:"cid"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+func getCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("get")
+	output := flags.String("o", "", "file to save to, - for stdout; the name of the file in the catalog by default")
+	timeout := flags.Duration("timeout", 0, "time to look for the content in the network, the default of the node when 0")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return &usageError{"Expected one CID"}
+	}
+	cid := flags.Arg(0)
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	download, err := c.Download(ctx, cid, &client.DownloadOptions{Timeout: *timeout})
+	if err != nil {
+		return err
+	}
+	defer download.Close()
+
+	name := *output
+	if name == "" {
+		name = downloadName(download.Filename, cid)
+	}
+	var w io.Writer = os.Stdout
+	if name != "-" {
+		file, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	bar := newProgressBar(name, download.Length)
+	n, err := io.Copy(w, &progressReader{r: download, bar: bar})
+	bar.Finish()
+	if err != nil {
+		if name != "-" {
+			os.Remove(name)
+		}
+		return err
+	}
+	if name == "-" {
+		return nil
+	}
+	if *jsonOutput {
+		return printJSON(downloadResult{CID: cid, Path: name, Size: n})
+	}
+	fmt.Printf("saved %s to %s\n", cid, name)
+	return nil
+}
+
+// downloadName is the name a download is saved under: its name in the
+// catalog, without any directory.
+func downloadName(filename, cid string) string {
+	name := filepath.Base(filename)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return cid
+	}
+	return name
+}
+
+func lsCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("ls")
+	text := flags.String("q", "", "terms that must all appear in the filename, type, description, tags or metadata")
+	name := flags.String("name", "", "substring of the filename")
+	typ := flags.String("type", "", "MIME type, or a range such as image/*")
+	owner := flags.String("owner", "", "user who uploaded the files")
+	tags := flags.String("tag", "", "comma-separated tags the files must all have")
+	allVersions := flags.Bool("all-versions", false, "list older versions of files too")
+	sortBy := flags.String("sort", client.SortUploadedAt, "uploadedAt, filename or size")
+	desc := flags.Bool("desc", false, "sort in descending order")
+	maxFiles := flags.Int("n", 0, "list at most this many files, all when 0")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return &usageError{"Unexpected arguments"}
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	query := client.ListQuery{
+		Text:        *text,
+		Name:        *name,
+		Type:        *typ,
+		Owner:       *owner,
+		AllVersions: *allVersions,
+		Sort:        *sortBy,
+		Desc:        *desc,
+	}
+	if *tags != "" {
+		query.Tags = strings.Split(*tags, ",")
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if !*jsonOutput {
+		fmt.Fprintln(tw, "CID\tSIZE\tUPLOADED\tVERSION\tNAME")
+	}
+	listed := 0
+	for {
+		query.Limit = maxPageSize
+		if *maxFiles > 0 && *maxFiles-listed < query.Limit {
+			query.Limit = *maxFiles - listed
+		}
+		page, err := c.List(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, f := range page.Files {
+			if *jsonOutput {
+				printJSON(f)
+			} else {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", f.CID, formatSize(f.Size), formatTime(f.UploadedAt), f.Version, f.Filename)
+			}
+		}
+		listed += len(page.Files)
+		if page.NextCursor == "" || (*maxFiles > 0 && listed >= *maxFiles) {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	return tw.Flush()
+}
+
+func rmCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("rm")
+	gc := flags.Bool("gc", false, "also delete the blocks no other file uses from the node right away")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return &usageError{"No CIDs to delete"}
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	for _, cid := range flags.Args() {
+		result, err := c.Delete(ctx, cid, *gc)
+		if err != nil {
+			return fmt.Errorf("deleting %s: %w", cid, err)
+		}
+		switch {
+		case *jsonOutput:
+			printJSON(result)
+		case *gc:
+			fmt.Printf("removed %s, %d blocks freed\n", result.CID, result.BlocksRemoved)
+		default:
+			fmt.Printf("removed %s\n", result.CID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Command ipfs-demo-cli is a command-line client of the HTTP API of a
+// node, for scripts and terminals:
+//
+//	ipfs-demo-cli [-api url] [-token token] [-json] <command> [flags] [args]
+//
+// Commands are add, get, ls, rm, pin, peers and watch; "ipfs-demo-cli
+// <command> -h" describes one.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"ipfs-demo/client"
+)
+
+var (
+	apiURL     = flag.String("api", envOr("IPFS_DEMO_API", "http://localhost:8000"), "URL of the HTTP API of the node, or $IPFS_DEMO_API")
+	apiToken   = flag.String("token", os.Getenv("IPFS_DEMO_TOKEN"), "API token or JWT to authenticate with, or $IPFS_DEMO_TOKEN")
+	jsonOutput = flag.Bool("json", false, "print results as JSON, one value per line")
+	noProgress = flag.Bool("no-progress", false, "do not show progress bars")
+)
+
+// command is a subcommand, which parses its own flags from args.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"add", "add [-r] [-encrypt] [-description text] [-tags a,b] [-folder /path] <file or dir>...", "upload files", addCommand},
+	{"get", "get [-o path] [-timeout 30s] <cid>", "download a file", getCommand},
+	{"ls", "ls [-q text] [-name s] [-type t] [-owner u] [-tag t] [-all-versions] [-sort field] [-desc] [-n max]", "list the catalog", lsCommand},
+	{"rm", "rm [-gc] <cid>...", "delete files", rmCommand},
+	{"pin", "pin ls | pin status <cid> | pin add [-min n] [-max n] [-name s] <cid> | pin rm <cid>", "manage cluster pins", pinCommand},
+	{"peers", "peers [-cluster]", "list the peers of the node", peersCommand},
+	{"watch", "watch [-reconnect=false]", "print catalog events as they happen", watchCommand},
+}
+
+// usageError is an error in the command line, reported with the usage.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func main() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: ipfs-demo-cli [flags] <command> [command flags] [args]\n\nCommands:\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(out, "  %-8s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintf(out, "\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	name, args := flag.Arg(0), flag.Args()[1:]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		running = cmd
+		err := cmd.run(ctx, args)
+		var uerr *usageError
+		switch {
+		case errors.Is(err, flag.ErrHelp):
+			os.Exit(2)
+		case errors.As(err, &uerr):
+			fmt.Fprintf(os.Stderr, "%s\nUsage: ipfs-demo-cli %s\n", uerr.msg, cmd.usage)
+			os.Exit(2)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n", name)
+	flag.Usage()
+	os.Exit(2)
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// running is the command being run.
+var running command
+
+// newFlagSet returns the flag set of the running command, which prints its
+// usage on errors.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ipfs-demo-cli %s\n\nFlags:\n", running.usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// newClient returns a client of the node of -api.
+func newClient() (*client.Client, error) {
+	c, err := client.New(*apiURL, &client.Options{Token: *apiToken})
+	if err != nil {
+		return nil, fmt.Errorf("invalid -api: %w", err)
+	}
+	return c, nil
+}
+
+// printJSON prints v on one line, for -json.
+func printJSON(v any) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+// formatSize formats a number of bytes with a binary unit.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
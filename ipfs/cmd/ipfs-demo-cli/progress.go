@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	progressWidth    = 30
+	progressInterval = 100 * time.Millisecond
+)
+
+// progressBar draws the progress of a transfer on stderr. A nil bar draws
+// nothing, so that callers do not check whether progress is shown.
+type progressBar struct {
+	label string
+	total int64 // -1 when unknown
+	done  int64
+	start time.Time
+	drawn time.Time
+}
+
+// newProgressBar returns a bar for a transfer of total bytes, or nil when
+// progress is not shown: with -no-progress or when stderr is not a
+// terminal.
+func newProgressBar(label string, total int64) *progressBar {
+	if *noProgress {
+		return nil
+	}
+	if stat, err := os.Stderr.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	if runes := []rune(label); len(runes) > progressWidth {
+		label = "..." + string(runes[len(runes)-progressWidth+3:])
+	}
+	return &progressBar{label: label, total: total, start: time.Now()}
+}
+
+func (p *progressBar) Add(n int) {
+	if p == nil {
+		return
+	}
+	p.done += int64(n)
+	if time.Since(p.drawn) >= progressInterval {
+		p.draw()
+	}
+}
+
+// Finish draws the final state of the bar and ends its line.
+func (p *progressBar) Finish() {
+	if p == nil {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+}
+
+func (p *progressBar) draw() {
+	p.drawn = time.Now()
+	rate := ""
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		rate = formatSize(int64(float64(p.done)/elapsed)) + "/s"
+	}
+	if p.total <= 0 {
+		fmt.Fprintf(os.Stderr, "\r%-*s %s %s\033[K", progressWidth, p.label, formatSize(p.done), rate)
+		return
+	}
+	filled := int(p.done * progressWidth / p.total)
+	if filled > progressWidth {
+		filled = progressWidth
+	}
+	fmt.Fprintf(os.Stderr, "\r%-*s [%s%s] %3d%% %s/%s %s\033[K", progressWidth, p.label,
+		strings.Repeat("#", filled), strings.Repeat(".", progressWidth-filled),
+		p.done*100/p.total, formatSize(p.done), formatSize(p.total), rate)
+}
+
+// progressReader advances a bar with the bytes read through it.
+type progressReader struct {
+	r   io.Reader
+	bar *progressBar
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.bar.Add(n)
+	return n, err
+}
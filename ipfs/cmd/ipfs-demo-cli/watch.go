@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"ipfs-demo/client"
+)
+
+func watchCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("watch")
+	reconnect := flags.Bool("reconnect", true, "reconnect when the connection is lost")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return &usageError{"Unexpected arguments"}
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := c.Subscribe(ctx)
+	if err != nil {
+		return err
+	}
+
+	var lost error
+	for event := range events {
+		switch event.Type {
+		case client.EventConnected:
+			fmt.Fprintf(os.Stderr, "watching %s\n", *apiURL)
+		case client.EventDisconnected:
+			if !*reconnect {
+				lost = event.Err
+				cancel()
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "connection lost: %s, reconnecting\n", event.Err.Error())
+		default:
+			if *jsonOutput {
+				fmt.Println(string(event.Raw))
+			} else if event.Type == client.EventFile {
+				fmt.Printf("file %s %s v%d %s\n", event.CID, event.File.Filename, event.File.Version, formatSize(event.File.Size))
+			} else {
+				fmt.Printf("%s %s\n", event.Type, event.CID)
+			}
+		}
+	}
+	if lost != nil {
+		return fmt.Errorf("connection lost: %w", lost)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+var (
+	httpAddr     = flag.String("addr", ":8000", "address the HTTP API listens on")
+	p2pPort      = flag.Int("p2p-port", 4001, "port the libp2p host listens on (TCP and QUIC)")
+	identitySeed = flag.Int64("identity-seed", 0, "seed for a deterministic peer identity, random when 0")
+	clusterName  = flag.String("cluster", "default", "name of the cluster this node shares its catalog with")
+	clusterPeers = flag.String("peers", "", "comma-separated multiaddrs of cluster peers to connect to on start")
+
+	keyDir         = flag.String("key-dir", "./keys", "directory holding the keys of encrypted files")
+	encryptUploads = flag.Bool("encrypt", false, "encrypt every upload, as if encrypt=true was given")
+
+	authTokensFile = flag.String("auth-tokens", "", "file of API tokens, one \"<token> <user> <roles> [<groups>]\" per line; enables authentication")
+	jwtKeyFile     = flag.String("jwt-key", "", "PEM public key or HMAC secret of the JWT issuer; enables authentication")
+	jwtIssuer      = flag.String("jwt-issuer", "", "required iss claim of JWTs")
+	jwtAudience    = flag.String("jwt-audience", "", "required aud claim of JWTs")
+
+	retrievalTimeout = flag.Duration("retrieval-timeout", time.Minute, "default time to look for content in the network before giving up, 0 for none")
+
+	quotasFile     = flag.String("quotas", "", "file of quotas, one \"user|group <name> <bytes> <files>\" per line")
+	rateLimitsFlag = flag.String("rate-limits", "", "comma-separated \"<class>=<rate>:<burst>:<concurrent>\" overriding the limits of the upload, download and api routes")
+
+	tlsCertFile          = flag.String("tls-cert", "", "PEM certificate to serve the API over TLS with, reloaded when it changes")
+	tlsKeyFile           = flag.String("tls-key", "", "PEM private key of -tls-cert")
+	tlsClientCAFile      = flag.String("tls-client-ca", "", "PEM CA of client certificates; enables authentication with them")
+	tlsRequireClientCert = flag.Bool("tls-require-client-cert", false, "refuse TLS clients without a certificate signed by -tls-client-ca")
+
+	corsOrigins     = flag.String("cors-origins", "http://localhost:3000", "comma-separated origins allowed to use the API from a browser, with * wildcards")
+	corsMethods     = flag.String("cors-methods", "GET,POST,PATCH,DELETE,HEAD", "comma-separated methods allowed in cross-origin requests")
+	corsHeaders     = flag.String("cors-headers", "Authorization,Content-Type,Accept,Range", "comma-separated headers allowed in cross-origin requests")
+	corsCredentials = flag.Bool("cors-credentials", false, "allow cross-origin requests with credentials")
+
+	shareKeyFile = flag.String("share-key", "", "file of the key signing share links, the same on every node; random when empty")
+
+	versionsKeep   = flag.Int("versions-keep", 0, "number of versions of a filename to keep, older ones are removed and unpinned; 0 keeps all")
+	versionsMaxAge = flag.Duration("versions-max-age", 0, "age after which versions other than the latest are removed and unpinned; 0 keeps them")
+
+	s3Addr     = flag.String("s3-addr", "", "address the S3-compatible API listens on, e.g. :9000; disabled when empty")
+	s3KeysFile = flag.String("s3-keys", "", "file of S3 keys, one \"<access key> <secret key> <user> <roles> [<groups>]\" per line; enables authentication")
+	s3MaxSize  = flag.Int64("s3-max-object-size", 5<<30, "largest object, in bytes, that a PutObject or a multipart upload can send")
+
+	foldersIPNS = flag.Bool("folders-ipns", false, "publish the root of the folder tree under the IPNS name of the node")
+
+	defaultReplicationMin = flag.Int("replication-min", 1, "minimum number of cluster nodes that pin each upload")
+	defaultReplicationMax = flag.Int("replication-max", 2, "maximum number of cluster nodes that pin each upload")
+)
+
+func parseMultiaddrs(s string) []multiaddr.Multiaddr {
+	var addrs []multiaddr.Multiaddr
+	for _, addrStr := range strings.Split(s, ",") {
+		addrStr = strings.TrimSpace(addrStr)
+		if addrStr == "" {
+			continue
+		}
+		addr, err := multiaddr.NewMultiaddr(addrStr)
+		if err != nil {
+			fmt.Printf("skipping invalid peer address %q: %s\n", addrStr, err.Error())
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	ipfslite "ipfs-demo/ipfs"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+)
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func pathCid(w http.ResponseWriter, r *http.Request) (cid.Cid, bool) {
+	c, err := cid.Decode(r.PathValue("cid"))
+	if err != nil {
+		writeErrorDetails(w, http.StatusBadRequest, CodeInvalidCID, "Invalid CID",
+			map[string]string{"cid": r.PathValue("cid"), "reason": err.Error()})
+		return cid.Undef, false
+	}
+	return c, true
+}
+
+func dagStatHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel, ok := retrievalContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	stat, err := ipfsNode.DAGStat(ctx, c)
+	if err != nil {
+		dagError(w, err)
+		return
+	}
+	writeJSON(w, stat)
+}
+
+func dagLinksHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel, ok := retrievalContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	links, err := ipfsNode.Links(ctx, c)
+	if err != nil {
+		dagError(w, err)
+		return
+	}
+	writeJSON(w, links)
+}
+
+func lsHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel, ok := retrievalContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	links, err := ipfsNode.Ls(ctx, c)
+	if err != nil {
+		dagError(w, err)
+		return
+	}
+	writeJSON(w, links)
+}
+
+func blockGetHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel, ok := retrievalContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	data, err := ipfsNode.BlockGet(ctx, c)
+	if err != nil {
+		dagError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.ipld.raw")
+	w.Write(data)
+}
+
+// Store the request body as a single block. The codec and hash function
+// default to raw and sha2-256 and can be set with the codec and mhtype
+// query parameters. Blocks are not in the catalog and escape quotas, so
+// only admins can put them.
+func blockPutHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, 2<<20)) // 2MB limit for a block
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not read block")
+		return
+	}
+
+	codec := r.URL.Query().Get("codec")
+	if codec == "" {
+		codec = "raw"
+	}
+	mhType := r.URL.Query().Get("mhtype")
+	if mhType == "" {
+		mhType = "sha2-256"
+	}
+
+	c, err := ipfsNode.BlockPut(r.Context(), data, codec, mhType)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Error storing block: %s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, ipfslite.BlockStat{CID: c.String(), Size: len(data)})
+}
+
+func blockStatHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel, ok := retrievalContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	stat, err := ipfsNode.BlockStat(ctx, c)
+	if err != nil {
+		dagError(w, err)
+		return
+	}
+	writeJSON(w, stat)
+}
+
+func blockRmHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+	if err := ipfsNode.BlockRm(r.Context(), c); err != nil {
+		dagError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func cidInspectHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+	info, err := ipfslite.InspectCID(c)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidCID, err.Error())
+		return
+	}
+	writeJSON(w, info)
+}
+
+// Media types of the structured data codecs. Plain JSON and CBOR are read
+// as their DAG variants.
+var dagMediaTypes = map[string]multicodec.Code{
+	"application/vnd.ipld.dag-json": multicodec.DagJson,
+	"application/json":              multicodec.DagJson,
+	"application/vnd.ipld.dag-cbor": multicodec.DagCbor,
+	"application/cbor":              multicodec.DagCbor,
+}
+
+func dagCodec(name string) (multicodec.Code, bool) {
+	switch name {
+	case "dag-json", "json":
+		return multicodec.DagJson, true
+	case "dag-cbor", "cbor":
+		return multicodec.DagCbor, true
+	}
+	return 0, false
+}
+
+// Pick the response codec from the format query parameter or the Accept
+// header, defaulting to dag-json.
+func negotiateDagCodec(r *http.Request) (multicodec.Code, bool) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return dagCodec(f)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accept))
+		if codec, ok := dagMediaTypes[mediaType]; ok {
+			return codec, true
+		}
+	}
+	return multicodec.DagJson, true
+}
+
+// Store a structured record. The body is read as dag-json unless its
+// Content-Type says dag-cbor, and is stored as dag-cbor unless the
+// store-codec query parameter says otherwise. Links are written as
+// {"/": "<cid>"} in dag-json. Like blocks, records escape quotas and only
+// admins can put them.
+func dagPutHandler(w http.ResponseWriter, r *http.Request) {
+	inputCodec := multicodec.DagJson
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, _ := mime.ParseMediaType(ct)
+		if codec, ok := dagMediaTypes[mediaType]; ok {
+			inputCodec = codec
+		}
+	}
+
+	storeCodec := multicodec.DagCbor
+	if v := r.URL.Query().Get("store-codec"); v != "" {
+		codec, ok := dagCodec(v)
+		if !ok {
+			invalidParameter(w, "store-codec", v)
+			return
+		}
+		storeCodec = codec
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, 2<<20)) // 2MB limit for a block
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not read record")
+		return
+	}
+	n, err := ipfslite.DecodeNode(data, inputCodec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid %s: %s", inputCodec, err.Error()))
+		return
+	}
+
+	c, err := ipfsNode.DagPut(r.Context(), n, storeCodec)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error storing record: %s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, map[string]string{"cid": c.String()})
+}
+
+// Resolve an IPLD path such as /ipld/{cid}/field/0/link and return the
+// node it points to, encoded as negotiated.
+func dagGetHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+	codec, ok := negotiateDagCodec(r)
+	if !ok {
+		writeError(w, http.StatusNotAcceptable, CodeNotAcceptable, "Unsupported format")
+		return
+	}
+
+	ctx, cancel, ok := retrievalContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	n, err := ipfsNode.DagGet(ctx, c, r.PathValue("path"))
+	if err != nil {
+		dagError(w, err)
+		return
+	}
+
+	data, err := ipfslite.EncodeNode(n, codec)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error encoding record: %s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.ipld."+codec.String())
+	w.Header().Set("Vary", "Accept")
+	w.Write(data)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contentDisposition formats a Content-Disposition header for a filename
+// as RFC 6266 recommends: an ASCII approximation in filename for old
+// clients, and the exact UTF-8 name in filename* when they differ.
+func contentDisposition(disposition, filename string) string {
+	var ascii strings.Builder
+	for _, r := range filename {
+		switch {
+		case r == '"' || r == '\\':
+			ascii.WriteByte('_')
+		case r < 0x20 || r > 0x7e:
+			ascii.WriteByte('_')
+		default:
+			ascii.WriteRune(r)
+		}
+	}
+
+	header := fmt.Sprintf("%s; filename=\"%s\"", disposition, ascii.String())
+	if ascii.String() != filename {
+		header += "; filename*=UTF-8''" + encodeExtValue(filename)
+	}
+	return header
+}
+
+// encodeExtValue percent-encodes everything but the attr-char of RFC 8187.
+func encodeExtValue(s string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte(attrChars, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	ipfslite "ipfs-demo/ipfs"
+	"net/http"
+	"time"
+
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Codes of APIError, stable for clients to switch on.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeInvalidCID       = "invalid_cid"
+	CodeInvalidParameter = "invalid_parameter"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeNotAcceptable    = "not_acceptable"
+	CodeConflict         = "conflict"
+	CodeGone             = "gone"
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeUnprocessable    = "unprocessable"
+	CodeRateLimited      = "rate_limited"
+	CodeTimeout          = "timeout"
+	CodeInternal         = "internal"
+)
+
+// APIError is the body of every error response.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// drop the headers of the content that was about to be served
+	w.Header().Del("Content-Disposition")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{Code: code, Message: message, Details: details})
+}
+
+// invalidParameter reports a query parameter that could not be parsed.
+func invalidParameter(w http.ResponseWriter, name, value string) {
+	writeErrorDetails(w, http.StatusBadRequest, CodeInvalidParameter,
+		fmt.Sprintf("Invalid %s", name), map[string]string{"parameter": name, "value": value})
+}
+
+// Map the errors of retrieving content to a status code.
+func dagError(w http.ResponseWriter, err error) {
+	switch {
+	case ipld.IsNotFound(err):
+		writeError(w, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, ipfslite.ErrPathNotFound):
+		writeError(w, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, ufsio.ErrNotADir):
+		writeError(w, http.StatusUnprocessableEntity, CodeUnprocessable, "Content is not a directory")
+	case errors.Is(err, ufsio.ErrIsDir):
+		writeError(w, http.StatusUnprocessableEntity, CodeUnprocessable, "Content is a directory, not a file")
+	case errors.Is(err, ufsio.ErrUnkownNodeType):
+		writeError(w, http.StatusUnprocessableEntity, CodeUnprocessable, "Content is not a UnixFS file")
+	case errors.Is(err, ipfslite.ErrDecrypt):
+		writeError(w, http.StatusUnprocessableEntity, CodeUnprocessable, err.Error())
+	case errors.Is(err, ipfslite.ErrFileNotFound):
+		writeError(w, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, ipfslite.ErrInvalidPath):
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+	case errors.Is(err, ipfslite.ErrPinned), errors.Is(err, ipfslite.ErrFileExists), errors.Is(err, ipfslite.ErrDirNotEmpty):
+		writeError(w, http.StatusConflict, CodeConflict, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, CodeTimeout, "Timed out retrieving the content from the network")
+	default:
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+}
+
+// maxRetrievalTimeout caps the timeout query parameter.
+const maxRetrievalTimeout = 10 * time.Minute
+
+// retrievalContext bounds the time spent retrieving content from the
+// network by the timeout query parameter, e.g. timeout=30s, or else by
+// -retrieval-timeout. It writes the error and returns false when the
+// parameter is invalid.
+func retrievalContext(w http.ResponseWriter, r *http.Request) (context.Context, context.CancelFunc, bool) {
+	timeout := *retrievalTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxRetrievalTimeout {
+			invalidParameter(w, "timeout", v)
+			return nil, nil, false
+		}
+		timeout = d
+	}
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, true
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, true
+}
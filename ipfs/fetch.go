@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	ipfslite "ipfs-demo/ipfs"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var fetchProgressInterval = 500 * time.Millisecond
+
+type FetchStatus struct {
+	CID    string `json:"cid"`
+	Blocks int    `json:"blocks"`
+	Bytes  uint64 `json:"bytes"`
+	Done   bool   `json:"done,omitempty"`
+	Pinned bool   `json:"pinned,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Fetch every block of a remote CID into the local node and pin it. The
+// response is a stream of newline-delimited FetchStatus objects reporting
+// progress, the last one having done set.
+func fetchHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+
+	parallelism := ipfslite.DefaultFetchParallelism
+	if v := r.URL.Query().Get("parallelism"); v != "" {
+		var err error
+		parallelism, err = strconv.Atoi(v)
+		if err != nil || parallelism < 1 {
+			invalidParameter(w, "parallelism", v)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	write := func(status FetchStatus) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(status)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	var last time.Time
+	progress, err := ipfsNode.Fetch(r.Context(), c, parallelism, func(p ipfslite.FetchProgress) {
+		mu.Lock()
+		if time.Since(last) < fetchProgressInterval {
+			mu.Unlock()
+			return
+		}
+		last = time.Now()
+		mu.Unlock()
+
+		write(FetchStatus{CID: c.String(), Blocks: p.Blocks, Bytes: p.Bytes})
+	})
+
+	status := FetchStatus{CID: c.String(), Blocks: progress.Blocks, Bytes: progress.Bytes, Done: true}
+	if err != nil {
+		fmt.Printf("error while fetching %s: %s\n", c, err.Error())
+		status.Error = err.Error()
+	} else {
+		status.Pinned = true
+	}
+	write(status)
+}
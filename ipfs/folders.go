@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	ipfslite "ipfs-demo/ipfs"
+	"net/http"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+)
+
+// folders is the virtual folder tree of this node. Folders link to files
+// by CID, so organizing uploads in them never copies content.
+var folders *ipfslite.Folders
+
+// FolderRoot is the state of the folder tree.
+type FolderRoot struct {
+	CID  string `json:"cid"`
+	IPNS string `json:"ipns,omitempty"` // name the root is published under, with -folders-ipns
+}
+
+func setUpFolderTree(ctx context.Context) (*ipfslite.Folders, error) {
+	var publish func(context.Context, cid.Cid) error
+	if *foldersIPNS {
+		publish = func(ctx context.Context, c cid.Cid) error {
+			if err := ipfsNode.PublishIPNS(ctx, c); err != nil {
+				fmt.Printf("error publishing folder root %s: %s\n", c, err.Error())
+				return err
+			}
+			fmt.Printf("published folder root %s as /ipns/%s\n", c, ipfsNode.IPNSName())
+			return nil
+		}
+	}
+	return ipfsNode.Folders(ctx, publish)
+}
+
+func folderRoot() FolderRoot {
+	root := FolderRoot{CID: folders.Root().String()}
+	if *foldersIPNS {
+		root.IPNS = ipfsNode.IPNSName()
+	}
+	return root
+}
+
+// boolParam reads an optional boolean query parameter. It writes the error
+// and returns false when the parameter is invalid.
+func boolParam(w http.ResponseWriter, r *http.Request, name string) (bool, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, true
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		invalidParameter(w, name, v)
+		return false, false
+	}
+	return b, true
+}
+
+// requiredParam reads a query parameter that must be set.
+func requiredParam(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		writeErrorDetails(w, http.StatusBadRequest, CodeInvalidParameter,
+			fmt.Sprintf("Missing %s", name), map[string]string{"parameter": name})
+		return "", false
+	}
+	return v, true
+}
+
+func getFolderRootHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, folderRoot())
+}
+
+// List a folder, the root when the path parameter is not set.
+func lsFolderHandler(w http.ResponseWriter, r *http.Request) {
+	pth := r.URL.Query().Get("path")
+	if pth == "" {
+		pth = "/"
+	}
+	entries, err := folders.Ls(r.Context(), pth)
+	if err != nil {
+		dagError(w, err)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+func statFolderHandler(w http.ResponseWriter, r *http.Request) {
+	pth, ok := requiredParam(w, r, "path")
+	if !ok {
+		return
+	}
+	entry, err := folders.Stat(r.Context(), pth)
+	if err != nil {
+		dagError(w, err)
+		return
+	}
+	writeJSON(w, entry)
+}
+
+func mkdirFolderHandler(w http.ResponseWriter, r *http.Request) {
+	pth, ok := requiredParam(w, r, "path")
+	if !ok {
+		return
+	}
+	parents, ok := boolParam(w, r, "parents")
+	if !ok {
+		return
+	}
+	if err := folders.Mkdir(r.Context(), pth, parents); err != nil {
+		dagError(w, err)
+		return
+	}
+	writeJSON(w, folderRoot())
+}
+
+// Copy a folder or file of the tree, or any UnixFS content given as
+// /ipfs/<cid>, such as an upload. Content missing from this node is looked
+// for in the network within the retrieval timeout.
+func cpFolderHandler(w http.ResponseWriter, r *http.Request) {
+	from, ok := requiredParam(w, r, "from")
+	if !ok {
+		return
+	}
+	to, ok := requiredParam(w, r, "to")
+	if !ok {
+		return
+	}
+	ctx, cancel, ok := retrievalContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	if err := folders.Cp(ctx, from, to); err != nil {
+		dagError(w, err)
+		return
+	}
+	writeJSON(w, folderRoot())
+}
+
+func mvFolderHandler(w http.ResponseWriter, r *http.Request) {
+	from, ok := requiredParam(w, r, "from")
+	if !ok {
+		return
+	}
+	to, ok := requiredParam(w, r, "to")
+	if !ok {
+		return
+	}
+	if err := folders.Mv(r.Context(), from, to); err != nil {
+		dagError(w, err)
+		return
+	}
+	writeJSON(w, folderRoot())
+}
+
+// Remove a file or folder from the tree. Its content stays in the catalog
+// and on the nodes that pin it.
+func rmFolderHandler(w http.ResponseWriter, r *http.Request) {
+	pth, ok := requiredParam(w, r, "path")
+	if !ok {
+		return
+	}
+	recursive, ok := boolParam(w, r, "recursive")
+	if !ok {
+		return
+	}
+	if err := folders.Rm(r.Context(), pth, recursive); err != nil {
+		dagError(w, err)
+		return
+	}
+	writeJSON(w, folderRoot())
+}
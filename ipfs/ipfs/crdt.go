@@ -0,0 +1,645 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	ipld "github.com/ipfs/go-ipld-format"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+var (
+	defaultRebroadcastInterval = time.Minute
+	defaultRepairInterval      = 5 * time.Minute
+	crdtFetchTimeout           = 30 * time.Second
+)
+
+// CRDTOptions configures a CRDT store. Hooks are called once an update is
+// merged, outside of the lock of the store, so they may block or use it.
+type CRDTOptions struct {
+	// RebroadcastInterval is how often the current heads are announced
+	// again, so that nodes which were offline learn about missed updates.
+	RebroadcastInterval time.Duration
+	// RepairInterval is how often blocks that could not be fetched are
+	// retried.
+	RepairInterval time.Duration
+	// PutHook is called when a key changes value because of an update
+	// received from another node.
+	PutHook func(key string, value []byte)
+	// DeleteHook is called when a key is removed because of an update
+	// received from another node.
+	DeleteHook func(key string)
+}
+
+// CRDT is a key-value store replicated between peers as a Merkle-CRDT, in
+// the style of go-ds-crdt. Every update is a delta stored as a DAG node
+// linking to the previous heads, and the heads are announced over pubsub.
+// Peers walk the DAG from announced heads, fetching the deltas they miss
+// through the Peer's DAGService.
+//
+// Values follow last-writer-wins semantics ordered by DAG height, with ties
+// broken by comparing values, so every peer converges on the same state.
+// Deletes only remove the values the deleting peer has seen: a concurrent
+// put wins over a delete.
+type CRDT struct {
+	peer  *Peer
+	store datastore.Datastore
+	topic string
+	opts  CRDTOptions
+
+	mu sync.Mutex // serializes merges and head updates
+}
+
+type crdtDelta struct {
+	Priority   uint64        `json:"priority"`
+	Elements   []crdtElement `json:"elements,omitempty"`
+	Tombstones []crdtElement `json:"tombstones,omitempty"`
+}
+
+type crdtElement struct {
+	Key   string `json:"key"`
+	ID    string `json:"id,omitempty"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type crdtValue struct {
+	Value    []byte `json:"value"`
+	Priority uint64 `json:"priority"`
+}
+
+type crdtBroadcast struct {
+	Heads []string `json:"heads"`
+}
+
+// CRDTStatus describes the replication state of a CRDT store.
+type CRDTStatus struct {
+	Heads  []string `json:"heads"`
+	Height uint64   `json:"height"`
+	Dirty  []string `json:"dirty"`
+}
+
+// NewCRDT creates a CRDT store whose state is kept in the Peer's datastore
+// under the given namespace and replicated on the given pubsub topic. It
+// runs until ctx is cancelled.
+func (p *Peer) NewCRDT(ctx context.Context, ns, topic string, opts *CRDTOptions) (*CRDT, error) {
+	c := &CRDT{
+		peer:  p,
+		store: namespace.Wrap(p.store, datastore.NewKey("/crdt/"+ns)),
+		topic: topic,
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.RebroadcastInterval == 0 {
+		c.opts.RebroadcastInterval = defaultRebroadcastInterval
+	}
+	if c.opts.RepairInterval == 0 {
+		c.opts.RepairInterval = defaultRepairInterval
+	}
+
+	sub, err := p.Subscribe(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	go c.handleBroadcasts(ctx, sub)
+	go c.rebroadcast(ctx)
+	go c.repairLoop(ctx)
+
+	return c, nil
+}
+
+// Put sets the value of a key.
+func (c *CRDT) Put(ctx context.Context, key string, value []byte) error {
+	return c.commit(ctx, crdtDelta{
+		Elements: []crdtElement{{Key: key, Value: value}},
+	})
+}
+
+// Delete removes a key. It returns datastore.ErrNotFound if the key has no
+// value.
+func (c *CRDT) Delete(ctx context.Context, key string) error {
+	ids, err := c.elementIDs(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return datastore.ErrNotFound
+	}
+
+	delta := crdtDelta{}
+	for _, id := range ids {
+		delta.Tombstones = append(delta.Tombstones, crdtElement{Key: key, ID: id})
+	}
+	return c.commit(ctx, delta)
+}
+
+// Get returns the value of a key, or datastore.ErrNotFound.
+func (c *CRDT) Get(ctx context.Context, key string) ([]byte, error) {
+	v, ok, err := c.value(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, datastore.ErrNotFound
+	}
+	return v.Value, nil
+}
+
+// List returns every key with a value.
+func (c *CRDT) List(ctx context.Context) (map[string][]byte, error) {
+	res, err := c.store.Query(ctx, query.Query{Prefix: "/k", KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	keys := make(map[string]struct{})
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		// keys look like /k/<encoded key>/e/<id>
+		ns := datastore.RawKey(r.Key).Namespaces()
+		if len(ns) != 4 {
+			continue
+		}
+		key, err := decodeCRDTKey(ns[1])
+		if err != nil {
+			continue
+		}
+		keys[key] = struct{}{}
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for key := range keys {
+		v, ok, err := c.value(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			values[key] = v.Value
+		}
+	}
+	return values, nil
+}
+
+// Status reports the current heads and the blocks waiting for repair.
+func (c *CRDT) Status(ctx context.Context) (CRDTStatus, error) {
+	heads, height, err := c.heads(ctx)
+	if err != nil {
+		return CRDTStatus{}, err
+	}
+	dirty, err := c.dirty(ctx)
+	if err != nil {
+		return CRDTStatus{}, err
+	}
+
+	status := CRDTStatus{Height: height, Heads: []string{}, Dirty: []string{}}
+	for _, h := range heads {
+		status.Heads = append(status.Heads, h.String())
+	}
+	for _, d := range dirty {
+		status.Dirty = append(status.Dirty, d.String())
+	}
+	return status, nil
+}
+
+// Repair retries every block that could not be fetched before and walks
+// the DAG from the current heads, merging any delta that was missed.
+func (c *CRDT) Repair(ctx context.Context) error {
+	heads, _, err := c.heads(ctx)
+	if err != nil {
+		return err
+	}
+	dirty, err := c.dirty(ctx)
+	if err != nil {
+		return err
+	}
+
+	// A dirty block may be a head we failed to fetch when it was
+	// announced. Linking an older block as an extra head is harmless.
+	var errs []error
+	for _, root := range dirty {
+		if err := c.process(ctx, root, true); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, root := range heads {
+		if err := c.process(ctx, root, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// commit stores a local delta on top of the current heads, merges it and
+// announces the new head.
+func (c *CRDT) commit(ctx context.Context, delta crdtDelta) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	heads, height, err := c.heads(ctx)
+	if err != nil {
+		return err
+	}
+	delta.Priority = height + 1
+
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	nd := merkledag.NodeWithData(data)
+	nd.SetCidBuilder(merkledag.V1CidPrefix())
+	for _, h := range heads {
+		if err := nd.AddRawLink("", &ipld.Link{Cid: h}); err != nil {
+			return err
+		}
+	}
+	if err := c.peer.Add(ctx, nd); err != nil {
+		return err
+	}
+
+	if err := c.merge(ctx, nd.Cid(), delta); err != nil {
+		return err
+	}
+	for _, h := range heads {
+		c.store.Delete(ctx, headKey(h))
+	}
+	if err := c.store.Put(ctx, headKey(nd.Cid()), []byte(strconv.FormatUint(delta.Priority, 10))); err != nil {
+		return err
+	}
+
+	return c.broadcast(ctx, []cid.Cid{nd.Cid()})
+}
+
+// fetchedDelta is a delta fetched by process, with the CID of its block
+// and the blocks it links to.
+type fetchedDelta struct {
+	c     cid.Cid
+	delta crdtDelta
+	links []cid.Cid
+}
+
+// process fetches the DAG under root down to the deltas already merged,
+// merges the missing ones and, when root was announced as a head, records
+// it as one.
+func (c *CRDT) process(ctx context.Context, root cid.Cid, isHead bool) error {
+	var nodes []fetchedDelta
+	var fetchErr error
+	seen := map[cid.Cid]bool{root: true}
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if done, _ := c.store.Has(ctx, processedKey(cur)); done {
+			continue
+		}
+
+		fctx, cancel := context.WithTimeout(ctx, crdtFetchTimeout)
+		nd, err := c.peer.Get(fctx, cur)
+		cancel()
+		if err != nil {
+			// remember the block so repair can retry it later
+			c.store.Put(ctx, dirtyKey(cur), nil)
+			fetchErr = errors.Join(fetchErr, err)
+			continue
+		}
+
+		pn, ok := nd.(*merkledag.ProtoNode)
+		if !ok {
+			continue
+		}
+		var delta crdtDelta
+		if err := json.Unmarshal(pn.Data(), &delta); err != nil {
+			continue
+		}
+
+		f := fetchedDelta{c: cur, delta: delta}
+		for _, l := range pn.Links() {
+			f.links = append(f.links, l.Cid)
+			if !seen[l.Cid] {
+				seen[l.Cid] = true
+				stack = append(stack, l.Cid)
+			}
+		}
+		nodes = append(nodes, f)
+	}
+
+	hooks, err := c.mergeFetched(ctx, root, isHead, nodes)
+	// hooks run once the lock is released, so that they can block or use
+	// the store without holding up merges
+	for _, hook := range hooks {
+		hook()
+	}
+	if err != nil {
+		return err
+	}
+	return fetchErr
+}
+
+// mergeFetched merges fetched deltas under the lock, records root as a head
+// when it was announced as one, and returns the hook calls for the keys
+// that changed.
+func (c *CRDT) mergeFetched(ctx context.Context, root cid.Cid, isHead bool, nodes []fetchedDelta) ([]func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	before := make(map[string]*crdtValue)
+	for _, f := range nodes {
+		for _, e := range append(f.delta.Elements, f.delta.Tombstones...) {
+			if _, ok := before[e.Key]; ok {
+				continue
+			}
+			before[e.Key] = nil
+			if v, ok, _ := c.value(ctx, e.Key); ok {
+				before[e.Key] = &v
+			}
+		}
+	}
+
+	var err error
+	for _, f := range nodes {
+		if err = c.merge(ctx, f.c, f.delta); err != nil {
+			break
+		}
+		c.store.Delete(ctx, dirtyKey(f.c))
+		// deltas below a new head are no longer heads themselves
+		for _, l := range f.links {
+			c.store.Delete(ctx, headKey(l))
+		}
+	}
+
+	if err == nil && isHead && len(nodes) > 0 && nodes[0].c == root {
+		err = c.store.Put(ctx, headKey(root), []byte(strconv.FormatUint(nodes[0].delta.Priority, 10)))
+	}
+
+	// deltas merged before an error still changed the state
+	return c.hooks(ctx, before), err
+}
+
+// merge applies a delta to the state. Elements are identified by the CID
+// of the block that introduced them.
+func (c *CRDT) merge(ctx context.Context, blk cid.Cid, delta crdtDelta) error {
+	if done, _ := c.store.Has(ctx, processedKey(blk)); done {
+		return nil
+	}
+
+	for _, t := range delta.Tombstones {
+		if err := c.store.Put(ctx, tombstoneKey(t.Key, t.ID), nil); err != nil {
+			return err
+		}
+	}
+	for _, e := range delta.Elements {
+		v, err := json.Marshal(crdtValue{Value: e.Value, Priority: delta.Priority})
+		if err != nil {
+			return err
+		}
+		if err := c.store.Put(ctx, elementKey(e.Key, blk.String()), v); err != nil {
+			return err
+		}
+	}
+
+	return c.store.Put(ctx, processedKey(blk), nil)
+}
+
+// hooks returns the hook calls for the keys whose value differs from
+// before.
+func (c *CRDT) hooks(ctx context.Context, before map[string]*crdtValue) []func() {
+	var calls []func()
+	for key, old := range before {
+		v, ok, err := c.value(ctx, key)
+		if err != nil {
+			continue
+		}
+		switch {
+		case ok && (old == nil || string(old.Value) != string(v.Value)):
+			if c.opts.PutHook != nil {
+				calls = append(calls, func() { c.opts.PutHook(key, v.Value) })
+			}
+		case !ok && old != nil:
+			if c.opts.DeleteHook != nil {
+				calls = append(calls, func() { c.opts.DeleteHook(key) })
+			}
+		}
+	}
+	return calls
+}
+
+// value resolves the current value of a key: the live element with the
+// highest priority wins, and ties go to the greater value.
+func (c *CRDT) value(ctx context.Context, key string) (crdtValue, bool, error) {
+	res, err := c.store.Query(ctx, query.Query{Prefix: keyPrefix(key) + "/e"})
+	if err != nil {
+		return crdtValue{}, false, err
+	}
+	defer res.Close()
+
+	var best crdtValue
+	found := false
+	for r := range res.Next() {
+		if r.Error != nil {
+			return crdtValue{}, false, r.Error
+		}
+		id := datastore.RawKey(r.Key).BaseNamespace()
+		if dead, _ := c.store.Has(ctx, tombstoneKey(key, id)); dead {
+			continue
+		}
+
+		var v crdtValue
+		if err := json.Unmarshal(r.Value, &v); err != nil {
+			continue
+		}
+		if !found || v.Priority > best.Priority ||
+			(v.Priority == best.Priority && string(v.Value) > string(best.Value)) {
+			best = v
+			found = true
+		}
+	}
+	return best, found, nil
+}
+
+// elementIDs lists the live elements of a key.
+func (c *CRDT) elementIDs(ctx context.Context, key string) ([]string, error) {
+	res, err := c.store.Query(ctx, query.Query{Prefix: keyPrefix(key) + "/e", KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var ids []string
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		id := datastore.RawKey(r.Key).BaseNamespace()
+		if dead, _ := c.store.Has(ctx, tombstoneKey(key, id)); !dead {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (c *CRDT) heads(ctx context.Context) ([]cid.Cid, uint64, error) {
+	res, err := c.store.Query(ctx, query.Query{Prefix: "/h"})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Close()
+
+	var heads []cid.Cid
+	var height uint64
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, 0, r.Error
+		}
+		h, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			continue
+		}
+		heads = append(heads, h)
+		if prio, _ := strconv.ParseUint(string(r.Value), 10, 64); prio > height {
+			height = prio
+		}
+	}
+	return heads, height, nil
+}
+
+func (c *CRDT) dirty(ctx context.Context) ([]cid.Cid, error) {
+	res, err := c.store.Query(ctx, query.Query{Prefix: "/d", KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var dirty []cid.Cid
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		if d, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace()); err == nil {
+			dirty = append(dirty, d)
+		}
+	}
+	return dirty, nil
+}
+
+func (c *CRDT) broadcast(ctx context.Context, heads []cid.Cid) error {
+	msg := crdtBroadcast{}
+	for _, h := range heads {
+		msg.Heads = append(msg.Heads, h.String())
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.peer.Publish(ctx, c.topic, data)
+}
+
+func (c *CRDT) handleBroadcasts(ctx context.Context, sub *pubsub.Subscription) {
+	defer sub.Cancel()
+
+	self := c.peer.host.ID()
+	for {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			return
+		}
+		if msg.GetFrom() == self {
+			continue
+		}
+
+		var bcast crdtBroadcast
+		if err := json.Unmarshal(msg.GetData(), &bcast); err != nil {
+			continue
+		}
+		for _, h := range bcast.Heads {
+			head, err := cid.Decode(h)
+			if err != nil {
+				continue
+			}
+			go func() {
+				if err := c.process(ctx, head, true); err != nil {
+					log.Printf("crdt: error processing head %s from %s: %s", head, msg.GetFrom(), err)
+				}
+			}()
+		}
+	}
+}
+
+func (c *CRDT) rebroadcast(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.RebroadcastInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			heads, _, err := c.heads(ctx)
+			if err != nil || len(heads) == 0 {
+				continue
+			}
+			if err := c.broadcast(ctx, heads); err != nil {
+				log.Printf("crdt: error rebroadcasting heads: %s", err)
+			}
+		}
+	}
+}
+
+func (c *CRDT) repairLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.RepairInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Repair(ctx); err != nil {
+				log.Printf("crdt: repair incomplete: %s", err)
+			}
+		}
+	}
+}
+
+func keyPrefix(key string) string {
+	return "/k/" + base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCRDTKey(s string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	return string(b), err
+}
+
+func elementKey(key, id string) datastore.Key {
+	return datastore.NewKey(keyPrefix(key) + "/e/" + id)
+}
+
+func tombstoneKey(key, id string) datastore.Key {
+	return datastore.NewKey(keyPrefix(key) + "/t/" + id)
+}
+
+func headKey(c cid.Cid) datastore.Key {
+	return datastore.NewKey("/h/" + c.String())
+}
+
+func processedKey(c cid.Cid) datastore.Key {
+	return datastore.NewKey("/p/" + c.String())
+}
+
+func dirtyKey(c cid.Cid) datastore.Key {
+	return datastore.NewKey("/d/" + c.String())
+}
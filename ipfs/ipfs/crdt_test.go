@@ -0,0 +1,229 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+)
+
+func newTestPeer(t *testing.T, ctx context.Context) *Peer {
+	t.Helper()
+	key, err := NewIdentity(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := NewInMemoryDatastore()
+	h, dht, err := SetupLibp2p(ctx, key, nil, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	p, err := New(ctx, ds, h, dht)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// crdtOp is a step of a CRDT test: a put, a delete, or a merge of the
+// heads of one replica into the other.
+type crdtOp struct {
+	replica int // 0 or 1
+	op      string
+	key     string
+	value   string
+}
+
+func put(replica int, key, value string) crdtOp { return crdtOp{replica, "put", key, value} }
+func del(replica int, key string) crdtOp        { return crdtOp{replica, "delete", key, ""} }
+func merge(from int) crdtOp                     { return crdtOp{replica: from, op: "merge"} }
+
+func TestCRDTMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []crdtOp
+		want map[string]string // on both replicas once merged both ways
+	}{
+		{
+			name: "put replicates",
+			ops:  []crdtOp{put(0, "a", "1")},
+			want: map[string]string{"a": "1"},
+		},
+		{
+			name: "later put wins",
+			ops:  []crdtOp{put(0, "a", "1"), merge(0), put(1, "a", "2")},
+			want: map[string]string{"a": "2"},
+		},
+		{
+			name: "higher priority wins over greater value",
+			ops:  []crdtOp{put(0, "a", "1"), put(0, "a", "2"), put(1, "a", "9")},
+			want: map[string]string{"a": "2"},
+		},
+		{
+			name: "concurrent puts resolve to the greater value",
+			ops:  []crdtOp{put(0, "a", "x"), put(1, "a", "y")},
+			want: map[string]string{"a": "y"},
+		},
+		{
+			name: "delete replicates",
+			ops:  []crdtOp{put(0, "a", "1"), put(0, "b", "2"), merge(0), del(1, "a")},
+			want: map[string]string{"b": "2"},
+		},
+		{
+			name: "concurrent put wins over delete",
+			ops:  []crdtOp{put(0, "a", "1"), merge(0), del(0, "a"), put(1, "a", "2")},
+			want: map[string]string{"a": "2"},
+		},
+		{
+			name: "delete after merge removes both values",
+			ops:  []crdtOp{put(0, "a", "1"), put(1, "a", "2"), merge(0), merge(1), del(0, "a")},
+			want: map[string]string{},
+		},
+		{
+			name: "put after delete is kept",
+			ops:  []crdtOp{put(0, "a", "1"), del(0, "a"), put(0, "a", "3")},
+			want: map[string]string{"a": "3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			p := newTestPeer(t, ctx)
+
+			// Both replicas live on one peer, which holds the blocks of
+			// both, and only merge when the test says so
+			var replicas [2]*CRDT
+			for i, ns := range []string{"a", "b"} {
+				c, err := p.NewCRDT(ctx, ns, "test/"+ns, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				replicas[i] = c
+			}
+			mergeFrom := func(from int) {
+				heads, _, err := replicas[from].heads(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, h := range heads {
+					if err := replicas[1-from].process(ctx, h, true); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			for _, op := range tt.ops {
+				var err error
+				switch op.op {
+				case "put":
+					err = replicas[op.replica].Put(ctx, op.key, []byte(op.value))
+				case "delete":
+					err = replicas[op.replica].Delete(ctx, op.key)
+				case "merge":
+					mergeFrom(op.replica)
+				}
+				if err != nil {
+					t.Fatalf("%s %s: %s", op.op, op.key, err)
+				}
+			}
+			mergeFrom(0)
+			mergeFrom(1)
+
+			for i, c := range replicas {
+				values, err := c.List(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(values) != len(tt.want) {
+					t.Errorf("replica %d has %d keys, want %d", i, len(values), len(tt.want))
+				}
+				for key, want := range tt.want {
+					if got := string(values[key]); got != want {
+						t.Errorf("replica %d: %s = %q, want %q", i, key, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCRDTHooks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newTestPeer(t, ctx)
+
+	src, err := p.NewCRDT(ctx, "src", "test/src", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst *CRDT
+	var events []string
+	dst, err = p.NewCRDT(ctx, "dst", "test/dst", &CRDTOptions{
+		// hooks run outside of the lock, so they can use the store
+		PutHook: func(key string, value []byte) {
+			got, err := dst.Get(ctx, key)
+			if err != nil || string(got) != string(value) {
+				t.Errorf("hook read %s = %q, %v, want %q", key, got, err, value)
+			}
+			if err := dst.Put(ctx, "seen/"+key, value); err != nil {
+				t.Errorf("hook write: %s", err)
+			}
+			events = append(events, "put "+key+"="+string(value))
+		},
+		DeleteHook: func(key string) {
+			if _, err := dst.Get(ctx, key); !errors.Is(err, datastore.ErrNotFound) {
+				t.Errorf("hook read deleted %s: %v", key, err)
+			}
+			events = append(events, "delete "+key)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syncHeads := func() {
+		heads, _, err := src.heads(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range heads {
+			if err := dst.process(ctx, h, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	steps := []struct {
+		change func() error
+		want   string
+	}{
+		{func() error { return src.Put(ctx, "k", []byte("1")) }, "put k=1"},
+		{func() error { return src.Put(ctx, "k", []byte("2")) }, "put k=2"},
+		{func() error { return src.Delete(ctx, "k") }, "delete k"},
+	}
+	for _, step := range steps {
+		events = nil
+		if err := step.change(); err != nil {
+			t.Fatal(err)
+		}
+		syncHeads()
+		if len(events) != 1 || events[0] != step.want {
+			t.Errorf("hooks = %v, want [%s]", events, step.want)
+		}
+	}
+
+	if seen, err := dst.Get(ctx, "seen/k"); err != nil || string(seen) != "2" {
+		t.Errorf("value written by the hook = %q, %v, want \"2\"", seen, err)
+	}
+
+	// merging the same heads again changes nothing
+	events = nil
+	syncHeads()
+	if len(events) != 0 {
+		t.Errorf("hooks on a second merge = %v, want none", events)
+	}
+}
@@ -0,0 +1,278 @@
+package ipfslite
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Encrypted files are split into segments sealed separately with
+// AES-256-GCM, so that any range can be decrypted without reading the
+// whole file. The layout is
+//
+//	nonce prefix (8 bytes) | segment 0 | segment 1 | ... | last segment
+//
+// where every segment but the last holds encryptedSegmentSize bytes of
+// plaintext plus the GCM tag. The nonce of a segment is the prefix followed
+// by the segment index, with the top bit set on the last segment so that a
+// truncated file fails to decrypt.
+const (
+	EncryptionKeySize    = 32
+	encryptedSegmentSize = 64 << 10
+	noncePrefixSize      = 8
+	lastSegmentFlag      = 1 << 31
+)
+
+var ErrDecrypt = errors.New("could not decrypt content")
+
+// NewEncryptionKey returns a random key for EncryptReader.
+func NewEncryptionKey() ([]byte, error) {
+	key := make([]byte, EncryptionKeySize)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+4)
+	copy(nonce, prefix)
+	if last {
+		index |= lastSegmentFlag
+	}
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+	return nonce
+}
+
+type encryptReader struct {
+	src    *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buf    []byte // sealed data not read yet
+	plain  []byte
+	done   bool
+}
+
+// EncryptReader returns a reader of the encrypted form of r.
+func EncryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	return &encryptReader{
+		src:    bufio.NewReaderSize(r, encryptedSegmentSize),
+		aead:   aead,
+		prefix: prefix,
+		buf:    append([]byte{}, prefix...),
+		plain:  make([]byte, encryptedSegmentSize),
+	}, nil
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.buf) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.sealNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.buf)
+	e.buf = e.buf[n:]
+	return n, nil
+}
+
+func (e *encryptReader) sealNext() error {
+	n, err := io.ReadFull(e.src, e.plain)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	// the segment is the last one when nothing follows it
+	last := n < len(e.plain)
+	if !last {
+		if _, err := e.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if e.index&lastSegmentFlag != 0 {
+		return fmt.Errorf("file too large to encrypt")
+	}
+
+	e.buf = e.aead.Seal(e.buf[:0], segmentNonce(e.prefix, e.index, last), e.plain[:n], nil)
+	e.index++
+	e.done = last
+	return nil
+}
+
+type decryptReader struct {
+	src       io.ReadSeeker
+	closer    io.Closer
+	aead      cipher.AEAD
+	prefix    []byte
+	segments  int64
+	plainSize int64
+
+	pos    int64
+	cached int64 // index of the segment in plain, -1 when none
+	plain  []byte
+	sealed []byte
+}
+
+// DecryptReader returns a seekable reader of the plaintext of src, an
+// encrypted file of the given size. It is closed along with src if src is
+// an io.Closer.
+func DecryptReader(src io.ReadSeeker, size int64, key []byte) (io.ReadSeekCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealedSegmentSize := int64(encryptedSegmentSize + aead.Overhead())
+	body := size - noncePrefixSize
+	if body < int64(aead.Overhead()) {
+		return nil, ErrDecrypt
+	}
+	segments := (body + sealedSegmentSize - 1) / sealedSegmentSize
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(src, prefix); err != nil {
+		return nil, err
+	}
+
+	d := &decryptReader{
+		src:       src,
+		aead:      aead,
+		prefix:    prefix,
+		segments:  segments,
+		plainSize: body - segments*int64(aead.Overhead()),
+		cached:    -1,
+		sealed:    make([]byte, sealedSegmentSize),
+	}
+	if c, ok := src.(io.Closer); ok {
+		d.closer = c
+	}
+	return d, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	if d.pos >= d.plainSize {
+		return 0, io.EOF
+	}
+
+	index := d.pos / encryptedSegmentSize
+	if index != d.cached {
+		if err := d.openSegment(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain[d.pos%encryptedSegmentSize:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+func (d *decryptReader) openSegment(index int64) error {
+	sealedSegmentSize := int64(len(d.sealed))
+	if _, err := d.src.Seek(noncePrefixSize+index*sealedSegmentSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	n, err := io.ReadFull(d.src, d.sealed)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+
+	last := index == d.segments-1
+	nonce := segmentNonce(d.prefix, uint32(index), last)
+	d.plain, err = d.aead.Open(d.plain[:0], nonce, d.sealed[:n], nil)
+	if err != nil {
+		d.cached = -1
+		return ErrDecrypt
+	}
+	d.cached = index
+	return nil
+}
+
+func (d *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = d.pos + offset
+	case io.SeekEnd:
+		pos = d.plainSize + offset
+	default:
+		return d.pos, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return d.pos, errors.New("negative position")
+	}
+	d.pos = pos
+	return pos, nil
+}
+
+func (d *decryptReader) Close() error {
+	if d.closer != nil {
+		return d.closer.Close()
+	}
+	return nil
+}
+
+// AddEncryptedFile encrypts r with key before chunking it, and returns the
+// root of the encrypted DAG. Only the ciphertext is stored.
+func (p *Peer) AddEncryptedFile(ctx context.Context, r io.Reader, key []byte) (ipld.Node, error) {
+	er, err := EncryptReader(r, key)
+	if err != nil {
+		return nil, err
+	}
+	return p.AddFile(ctx, er)
+}
+
+// GetEncryptedFile returns a seekable reader of the plaintext of a file
+// added with AddEncryptedFile.
+func (p *Peer) GetEncryptedFile(ctx context.Context, c cid.Cid, key []byte) (io.ReadSeekCloser, error) {
+	n, err := p.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := ufsio.NewDagReader(ctx, n, p)
+	if err != nil {
+		return nil, err
+	}
+	rsc, err := DecryptReader(dr, int64(dr.Size()), key)
+	if err != nil {
+		dr.Close()
+		return nil, err
+	}
+	return rsc, nil
+}
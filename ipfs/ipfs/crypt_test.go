@@ -0,0 +1,226 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func testPlaintext(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func encrypt(t *testing.T, data, key []byte) []byte {
+	t.Helper()
+	er, err := EncryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(er)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key, err := NewEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		size     int
+		segments int
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"one segment less a byte", encryptedSegmentSize - 1, 1},
+		{"one segment", encryptedSegmentSize, 1},
+		{"one segment and a byte", encryptedSegmentSize + 1, 2},
+		{"two segments", 2 * encryptedSegmentSize, 2},
+		{"several segments", 3*encryptedSegmentSize + 100, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testPlaintext(tt.size)
+			sealed := encrypt(t, data, key)
+
+			// every segment carries its own GCM tag
+			want := noncePrefixSize + tt.size + tt.segments*16
+			if len(sealed) != want {
+				t.Fatalf("encrypted size = %d, want %d", len(sealed), want)
+			}
+
+			dr, err := DecryptReader(bytes.NewReader(sealed), int64(len(sealed)), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dr.Close()
+			got, err := io.ReadAll(dr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("decrypted %d bytes, not the %d bytes encrypted", len(got), len(data))
+			}
+			if end, err := dr.Seek(0, io.SeekEnd); err != nil || end != int64(tt.size) {
+				t.Errorf("seek to end = %d, %v, want %d", end, err, tt.size)
+			}
+		})
+	}
+}
+
+func TestDecryptSeek(t *testing.T) {
+	key, err := NewEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 3*encryptedSegmentSize + 100
+	data := testPlaintext(size)
+	sealed := encrypt(t, data, key)
+
+	dr, err := DecryptReader(bytes.NewReader(sealed), int64(len(sealed)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dr.Close()
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		length int
+		want   int64 // position after the seek
+	}{
+		{"start", 0, io.SeekStart, 10, 0},
+		{"inside first segment", 1000, io.SeekStart, 10, 1000},
+		{"across segments", encryptedSegmentSize - 5, io.SeekStart, 10, encryptedSegmentSize - 5},
+		{"segment boundary", 2 * encryptedSegmentSize, io.SeekStart, 10, 2 * encryptedSegmentSize},
+		{"back from current", -encryptedSegmentSize, io.SeekCurrent, 10, encryptedSegmentSize + 10},
+		{"last segment from end", -50, io.SeekEnd, 50, int64(size) - 50},
+		{"past the end", 10, io.SeekEnd, 0, int64(size) + 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, err := dr.Seek(tt.offset, tt.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pos != tt.want {
+				t.Fatalf("position = %d, want %d", pos, tt.want)
+			}
+			got := make([]byte, tt.length)
+			n, err := io.ReadFull(dr, got)
+			if tt.length == 0 {
+				if err != nil && err != io.EOF {
+					t.Fatalf("read past the end: %s", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read %d bytes: %s", n, err)
+			}
+			if !bytes.Equal(got, data[pos:pos+int64(tt.length)]) {
+				t.Errorf("read at %d does not match the plaintext", pos)
+			}
+		})
+	}
+
+	if _, err := dr.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seek before the start succeeded")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key, err := NewEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := NewEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := encrypt(t, testPlaintext(2*encryptedSegmentSize+10), key)
+	sealedSegmentSize := encryptedSegmentSize + 16
+
+	tampered := bytes.Clone(sealed)
+	tampered[noncePrefixSize+sealedSegmentSize+3] ^= 1
+
+	tests := []struct {
+		name   string
+		sealed []byte
+		key    []byte
+	}{
+		{"wrong key", sealed, otherKey},
+		{"tampered segment", tampered, key},
+		{"truncated at a segment boundary", sealed[:noncePrefixSize+2*sealedSegmentSize], key},
+		{"truncated inside a segment", sealed[:len(sealed)-1], key},
+		{"last segment dropped of empty file", encrypt(t, nil, key)[:noncePrefixSize], key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dr, err := DecryptReader(bytes.NewReader(tt.sealed), int64(len(tt.sealed)), tt.key)
+			if err == nil {
+				_, err = io.ReadAll(dr)
+			}
+			if !errors.Is(err, ErrDecrypt) {
+				t.Errorf("error = %v, want %v", err, ErrDecrypt)
+			}
+		})
+	}
+}
+
+func TestEncryptedFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newTestPeer(t, ctx)
+	key, err := NewEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, encryptedSegmentSize, 5*encryptedSegmentSize + 7} {
+		data := testPlaintext(size)
+		n, err := p.AddEncryptedFile(ctx, bytes.NewReader(data), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the stored DAG holds only ciphertext
+		plain, err := p.GetFile(ctx, n.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := io.ReadAll(plain)
+		plain.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size > 0 && bytes.Contains(stored, data[:min(size, 64)]) {
+			t.Errorf("size %d: plaintext stored in the DAG", size)
+		}
+
+		rsc, err := p.GetEncryptedFile(ctx, n.Cid(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rsc.Seek(int64(size/2), io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rsc)
+		rsc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[size/2:]) {
+			t.Errorf("size %d: second half does not match", size)
+		}
+	}
+}
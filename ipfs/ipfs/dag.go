@@ -0,0 +1,208 @@
+package ipfslite
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// ErrPinned is returned when removing a block that is pinned.
+var ErrPinned = errors.New("block is pinned")
+
+// DAGStat describes a whole DAG.
+type DAGStat struct {
+	CID    string `json:"cid"`
+	Size   uint64 `json:"size"`   // cumulative size of all unique blocks
+	Blocks int    `json:"blocks"` // number of unique blocks
+	Depth  int    `json:"depth"`  // number of levels below the root
+}
+
+// Link is a named link from a DAG node to another.
+type Link struct {
+	Name string `json:"name"`
+	CID  string `json:"cid"`
+	Size uint64 `json:"size"`
+}
+
+// BlockStat describes a single block.
+type BlockStat struct {
+	CID  string `json:"cid"`
+	Size int    `json:"size"`
+}
+
+// CIDInfo breaks a CID down into its parts.
+type CIDInfo struct {
+	CID       string        `json:"cid"`
+	Version   uint64        `json:"version"`
+	Codec     string        `json:"codec"`
+	CodecCode uint64        `json:"codecCode"`
+	Multihash MultihashInfo `json:"multihash"`
+	CIDv0     string        `json:"cidV0,omitempty"`
+	CIDv1     string        `json:"cidV1"`
+}
+
+type MultihashInfo struct {
+	Name   string `json:"name"`
+	Code   uint64 `json:"code"`
+	Length int    `json:"length"`
+	Digest string `json:"digest"` // hex encoded
+}
+
+// DAGStat walks the DAG under c, fetching missing blocks, and returns its
+// cumulative size, block count and depth.
+func (p *Peer) DAGStat(ctx context.Context, c cid.Cid) (DAGStat, error) {
+	var mu sync.Mutex
+	stat := DAGStat{CID: c.String()}
+	seen := cid.NewSet()
+
+	ng := p.Session(ctx)
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		nd, err := ng.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		stat.Size += uint64(len(nd.RawData()))
+		stat.Blocks++
+		mu.Unlock()
+		return nd.Links(), nil
+	}
+	visit := func(c cid.Cid, depth int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if depth > stat.Depth {
+			stat.Depth = depth
+		}
+		return seen.Visit(c)
+	}
+
+	err := merkledag.WalkDepth(ctx, getLinks, c, visit, merkledag.Concurrency(DefaultFetchParallelism))
+	return stat, err
+}
+
+// Links lists the links of a single DAG node.
+func (p *Peer) Links(ctx context.Context, c cid.Cid) ([]Link, error) {
+	nd, err := p.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return toLinks(nd.Links()), nil
+}
+
+// Ls lists the entries of a UnixFS directory, including sharded ones.
+func (p *Peer) Ls(ctx context.Context, c cid.Cid) ([]Link, error) {
+	nd, err := p.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ufsio.NewDirectoryFromNode(p, nd)
+	if err != nil {
+		return nil, err
+	}
+	links, err := dir.Links(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return toLinks(links), nil
+}
+
+func toLinks(links []*ipld.Link) []Link {
+	res := make([]Link, 0, len(links))
+	for _, l := range links {
+		res = append(res, Link{Name: l.Name, CID: l.Cid.String(), Size: l.Size})
+	}
+	return res
+}
+
+// BlockGet returns the raw data of a block, fetching it if needed.
+func (p *Peer) BlockGet(ctx context.Context, c cid.Cid) ([]byte, error) {
+	b, err := p.bserv.GetBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return b.RawData(), nil
+}
+
+// BlockPut stores raw data as a block with the given codec and hash
+// function, e.g. "raw" and "sha2-256".
+func (p *Peer) BlockPut(ctx context.Context, data []byte, codec, mhType string) (cid.Cid, error) {
+	var mc multicodec.Code
+	if err := mc.Set(codec); err != nil {
+		return cid.Undef, err
+	}
+	mh, ok := multihash.Names[mhType]
+	if !ok {
+		return cid.Undef, errors.New("unknown hash function " + mhType)
+	}
+
+	prefix := cid.Prefix{Version: 1, Codec: uint64(mc), MhType: mh, MhLength: -1}
+	c, err := prefix.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	b, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return c, p.bserv.AddBlock(ctx, b)
+}
+
+// BlockStat returns the size of a block, fetching it if needed.
+func (p *Peer) BlockStat(ctx context.Context, c cid.Cid) (BlockStat, error) {
+	b, err := p.bserv.GetBlock(ctx, c)
+	if err != nil {
+		return BlockStat{}, err
+	}
+	return BlockStat{CID: c.String(), Size: len(b.RawData())}, nil
+}
+
+// BlockRm deletes a block from the local blockstore. Pinned roots cannot
+// be removed.
+func (p *Peer) BlockRm(ctx context.Context, c cid.Cid) error {
+	if pinned, _ := p.IsPinned(ctx, c); pinned {
+		return ErrPinned
+	}
+	has, err := p.bstore.Has(ctx, c)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return ipld.ErrNotFound{Cid: c}
+	}
+	return p.bstore.DeleteBlock(ctx, c)
+}
+
+// InspectCID describes the version, codec and multihash of a CID.
+func InspectCID(c cid.Cid) (CIDInfo, error) {
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return CIDInfo{}, err
+	}
+
+	info := CIDInfo{
+		CID:       c.String(),
+		Version:   c.Version(),
+		Codec:     multicodec.Code(c.Type()).String(),
+		CodecCode: c.Type(),
+		Multihash: MultihashInfo{
+			Name:   dmh.Name,
+			Code:   dmh.Code,
+			Length: dmh.Length,
+			Digest: hex.EncodeToString(dmh.Digest),
+		},
+		CIDv1: cid.NewCidV1(c.Type(), c.Hash()).String(),
+	}
+	if c.Type() == cid.DagProtobuf && dmh.Code == multihash.SHA2_256 {
+		info.CIDv0 = cid.NewCidV0(c.Hash()).String()
+	}
+	return info, nil
+}
@@ -0,0 +1,66 @@
+package ipfslite
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// DefaultFetchParallelism is the number of blocks Fetch requests at once
+// when no parallelism is given.
+const DefaultFetchParallelism = 8
+
+// FetchProgress reports how much of a DAG has been fetched so far.
+type FetchProgress struct {
+	Blocks int    `json:"blocks"`
+	Bytes  uint64 `json:"bytes"`
+}
+
+// Fetch walks the whole DAG under c using a bitswap session, storing every
+// block in the blockstore, and pins c once all blocks are local. Up to
+// parallelism blocks are requested at once. progress, if not nil, is called
+// after every block.
+func (p *Peer) Fetch(ctx context.Context, c cid.Cid, parallelism int, progress func(FetchProgress)) (FetchProgress, error) {
+	if parallelism <= 0 {
+		parallelism = DefaultFetchParallelism
+	}
+
+	var mu sync.Mutex
+	var total FetchProgress
+
+	ng := p.Session(ctx)
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		nd, err := ng.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		total.Blocks++
+		total.Bytes += uint64(len(nd.RawData()))
+		current := total
+		mu.Unlock()
+
+		if progress != nil {
+			progress(current)
+		}
+		return nd.Links(), nil
+	}
+
+	visited := cid.NewSet()
+	var visitedMu sync.Mutex
+	visit := func(c cid.Cid) bool {
+		visitedMu.Lock()
+		defer visitedMu.Unlock()
+		return visited.Visit(c)
+	}
+
+	err := merkledag.Walk(ctx, getLinks, c, visit, merkledag.Concurrency(parallelism))
+	if err != nil {
+		return total, err
+	}
+	return total, p.addPin(ctx, c)
+}
@@ -0,0 +1,469 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	gopath "path"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	ufsio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/boxo/mfs"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+var (
+	ErrFileNotFound = errors.New("no such file or directory")
+	ErrFileExists   = errors.New("file already exists")
+	ErrDirNotEmpty  = errors.New("directory is not empty")
+	ErrInvalidPath  = errors.New("invalid path")
+)
+
+// foldersRootKey is where the CID of the root directory is kept.
+var foldersRootKey = datastore.NewKey("/folders/root")
+
+// Folders is a mutable tree of UnixFS directories over the content of the
+// Peer, like the MFS of IPFS. Directories link to files by CID, so a file
+// can be in many folders without being stored twice. Every change makes a
+// new root directory, which is recorded in the datastore and pinned, so
+// nothing linked from the tree is garbage collected.
+type Folders struct {
+	p    *Peer
+	root *mfs.Root
+
+	mu      sync.Mutex // serializes operations, so that each has its own root
+	rootCid cid.Cid
+}
+
+// FolderEntry describes a file or directory of Folders.
+type FolderEntry struct {
+	Name           string `json:"name"`
+	Type           string `json:"type"` // file or directory
+	CID            string `json:"cid"`
+	Size           uint64 `json:"size"`           // size of the file content, 0 for directories
+	CumulativeSize uint64 `json:"cumulativeSize"` // size of the whole DAG
+}
+
+// Folders loads the folder tree of the Peer, creating an empty one the
+// first time. publish, if not nil, is called with the new root CID after
+// changes, at most every few seconds.
+func (p *Peer) Folders(ctx context.Context, publish func(context.Context, cid.Cid) error) (*Folders, error) {
+	var root *merkledag.ProtoNode
+	data, err := p.store.Get(ctx, foldersRootKey)
+	switch {
+	case err == nil:
+		c, err := cid.Cast(data)
+		if err != nil {
+			return nil, err
+		}
+		nd, err := p.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		pn, ok := nd.(*merkledag.ProtoNode)
+		if !ok {
+			return nil, ufsio.ErrNotADir
+		}
+		root = pn
+	case errors.Is(err, datastore.ErrNotFound):
+		root = ft.EmptyDirNode()
+		if err := p.Add(ctx, root); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	r, err := mfs.NewRoot(p.ctx, p, root, publish)
+	if err != nil {
+		return nil, err
+	}
+	f := &Folders{p: p, root: r}
+	return f, f.setRoot(ctx, root.Cid())
+}
+
+// Root returns the CID of the root directory.
+func (f *Folders) Root() cid.Cid {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rootCid
+}
+
+// setRoot records c as the root, pinning it in place of the previous one.
+func (f *Folders) setRoot(ctx context.Context, c cid.Cid) error {
+	if c == f.rootCid {
+		return nil
+	}
+	if err := f.p.addPin(ctx, c); err != nil {
+		return err
+	}
+	if err := f.p.store.Put(ctx, foldersRootKey, c.Bytes()); err != nil {
+		return err
+	}
+	if f.rootCid.Defined() {
+		if err := f.p.Unpin(ctx, f.rootCid); err != nil {
+			return err
+		}
+	}
+	f.rootCid = c
+	return nil
+}
+
+// flush propagates the changes made in dirs up to a new root.
+func (f *Folders) flush(ctx context.Context, dirs ...*mfs.Directory) error {
+	for _, dir := range dirs {
+		if err := dir.Flush(); err != nil {
+			return err
+		}
+	}
+	nd, err := f.root.GetDirectory().GetNode()
+	if err != nil {
+		return err
+	}
+	return f.setRoot(ctx, nd.Cid())
+}
+
+// cleanPath checks that pth is absolute and cleans it.
+func cleanPath(pth string) (string, error) {
+	if !strings.HasPrefix(pth, "/") {
+		return "", fmt.Errorf("%q: %w: paths must start with /", pth, ErrInvalidPath)
+	}
+	return gopath.Clean(pth), nil
+}
+
+// lookup returns the file or directory at the clean path pth.
+func (f *Folders) lookup(pth string) (mfs.FSNode, error) {
+	var cur mfs.FSNode = f.root.GetDirectory()
+	parts := strings.Split(strings.Trim(pth, "/"), "/")
+	for i, name := range parts {
+		if name == "" {
+			continue
+		}
+		dir, ok := cur.(*mfs.Directory)
+		if !ok {
+			return nil, fmt.Errorf("/%s: %w", strings.Join(parts[:i], "/"), ufsio.ErrNotADir)
+		}
+		child, err := dir.Child(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", pth, ErrFileNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cur = child
+	}
+	return cur, nil
+}
+
+func (f *Folders) lookupDir(pth string) (*mfs.Directory, error) {
+	fsn, err := f.lookup(pth)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", pth, ufsio.ErrNotADir)
+	}
+	return dir, nil
+}
+
+// target resolves the destination of a copy or move of a file called
+// name: into dst if it is a directory, else at dst itself. It fails if
+// the destination exists.
+func (f *Folders) target(dst, name string) (*mfs.Directory, string, error) {
+	fsn, err := f.lookup(dst)
+	if err == nil {
+		dir, ok := fsn.(*mfs.Directory)
+		if !ok {
+			return nil, "", fmt.Errorf("%s: %w", dst, ErrFileExists)
+		}
+		if _, err := dir.Child(name); err == nil {
+			return nil, "", fmt.Errorf("%s: %w", gopath.Join(dst, name), ErrFileExists)
+		}
+		return dir, name, nil
+	}
+	if !errors.Is(err, ErrFileNotFound) {
+		return nil, "", err
+	}
+	dirPath, base := gopath.Split(dst)
+	dir, err := f.lookupDir(dirPath)
+	return dir, base, err
+}
+
+func (f *Folders) entry(name string, fsn mfs.FSNode) (FolderEntry, error) {
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return FolderEntry{}, err
+	}
+	cumulative, err := nd.Size()
+	if err != nil {
+		return FolderEntry{}, err
+	}
+	e := FolderEntry{Name: name, Type: "directory", CID: nd.Cid().String(), CumulativeSize: cumulative}
+	if file, ok := fsn.(*mfs.File); ok {
+		size, err := file.Size()
+		if err != nil {
+			return FolderEntry{}, err
+		}
+		e.Type = "file"
+		e.Size = uint64(size)
+	}
+	return e, nil
+}
+
+// Stat describes the file or directory at pth.
+func (f *Folders) Stat(ctx context.Context, pth string) (FolderEntry, error) {
+	pth, err := cleanPath(pth)
+	if err != nil {
+		return FolderEntry{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fsn, err := f.lookup(pth)
+	if err != nil {
+		return FolderEntry{}, err
+	}
+	return f.entry(gopath.Base(pth), fsn)
+}
+
+// Ls lists the entries of the directory at pth, or describes the file at
+// pth.
+func (f *Folders) Ls(ctx context.Context, pth string) ([]FolderEntry, error) {
+	pth, err := cleanPath(pth)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fsn, err := f.lookup(pth)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		e, err := f.entry(gopath.Base(pth), fsn)
+		return []FolderEntry{e}, err
+	}
+
+	names, err := dir.ListNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]FolderEntry, 0, len(names))
+	for _, name := range names {
+		child, err := dir.Child(name)
+		if err != nil {
+			return nil, err
+		}
+		e, err := f.entry(name, child)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Mkdir creates a directory at pth. With parents, the missing directories
+// above it are created too, and an existing directory is not an error.
+func (f *Folders) Mkdir(ctx context.Context, pth string, parents bool) error {
+	pth, err := cleanPath(pth)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if pth == "/" {
+		if parents {
+			return nil
+		}
+		return fmt.Errorf("/: %w", ErrFileExists)
+	}
+
+	cur := f.root.GetDirectory()
+	parts := strings.Split(pth[1:], "/")
+	for i, name := range parts {
+		sub := "/" + strings.Join(parts[:i+1], "/")
+		child, err := cur.Child(name)
+		switch {
+		case err == nil:
+			dir, ok := child.(*mfs.Directory)
+			if !ok {
+				return fmt.Errorf("%s: %w", sub, ufsio.ErrNotADir)
+			}
+			if i == len(parts)-1 {
+				if parents {
+					return nil
+				}
+				return fmt.Errorf("%s: %w", sub, ErrFileExists)
+			}
+			cur = dir
+		case errors.Is(err, os.ErrNotExist):
+			if i < len(parts)-1 && !parents {
+				return fmt.Errorf("%s: %w", sub, ErrFileNotFound)
+			}
+			if cur, err = cur.Mkdir(name); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+	return f.flush(ctx, cur)
+}
+
+// Cp copies src to dst, or into dst if it is a directory. src is a path
+// of the tree, or /ipfs/<cid> to add any UnixFS file or directory, whose
+// whole DAG is fetched from the network if needed, so that the pin of the
+// root covers it. Only links are copied, never the content.
+func (f *Folders) Cp(ctx context.Context, src, dst string) error {
+	dst, err := cleanPath(dst)
+	if err != nil {
+		return err
+	}
+
+	var name string
+	var nd ipld.Node
+	if strings.HasPrefix(src, "/ipfs/") {
+		// fetched before locking the tree, which the network could hold
+		// up for long
+		name = strings.TrimPrefix(src, "/ipfs/")
+		c, err := cid.Decode(name)
+		if err != nil {
+			return fmt.Errorf("%q: %w: %s", src, ErrInvalidPath, err.Error())
+		}
+		if nd, err = f.p.Get(ctx, c); err != nil {
+			return err
+		}
+		// the tree can only hold UnixFS
+		switch nd := nd.(type) {
+		case *merkledag.ProtoNode:
+			if _, err := ft.FSNodeFromBytes(nd.Data()); err != nil {
+				return fmt.Errorf("%s: %w", src, ufsio.ErrUnkownNodeType)
+			}
+		case *merkledag.RawNode:
+		default:
+			return fmt.Errorf("%s: %w", src, ufsio.ErrUnkownNodeType)
+		}
+		if err := merkledag.FetchGraph(ctx, c, f.p); err != nil {
+			return err
+		}
+	} else if src, err = cleanPath(src); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if nd == nil {
+		fsn, err := f.lookup(src)
+		if err != nil {
+			return err
+		}
+		if nd, err = fsn.GetNode(); err != nil {
+			return err
+		}
+		name = gopath.Base(src)
+	}
+
+	dir, name, err := f.target(dst, name)
+	if err != nil {
+		return err
+	}
+	if err := dir.AddChild(name, nd); err != nil {
+		return err
+	}
+	return f.flush(ctx, dir)
+}
+
+// Mv moves src to dst, or into dst if it is a directory.
+func (f *Folders) Mv(ctx context.Context, src, dst string) error {
+	src, err := cleanPath(src)
+	if err != nil {
+		return err
+	}
+	if dst, err = cleanPath(dst); err != nil {
+		return err
+	}
+	if src == "/" {
+		return fmt.Errorf("/: %w: cannot move the root", ErrInvalidPath)
+	}
+	if dst == src || strings.HasPrefix(dst, src+"/") {
+		return fmt.Errorf("%s: %w: cannot move a directory into itself", dst, ErrInvalidPath)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	srcDirPath, name := gopath.Split(src)
+	srcDir, err := f.lookupDir(srcDirPath)
+	if err != nil {
+		return err
+	}
+	fsn, err := f.lookup(src)
+	if err != nil {
+		return err
+	}
+
+	dstDir, dstName, err := f.target(dst, name)
+	if err != nil {
+		return err
+	}
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return err
+	}
+	if err := dstDir.AddChild(dstName, nd); err != nil {
+		return err
+	}
+	if err := srcDir.Unlink(name); err != nil {
+		return err
+	}
+	return f.flush(ctx, srcDir, dstDir)
+}
+
+// Rm removes the file or directory at pth. Directories that are not empty
+// are only removed when recursive is set. The content stays in the
+// blockstore until it is garbage collected.
+func (f *Folders) Rm(ctx context.Context, pth string, recursive bool) error {
+	pth, err := cleanPath(pth)
+	if err != nil {
+		return err
+	}
+	if pth == "/" {
+		return fmt.Errorf("/: %w: cannot remove the root", ErrInvalidPath)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dirPath, name := gopath.Split(pth)
+	dir, err := f.lookupDir(dirPath)
+	if err != nil {
+		return err
+	}
+	fsn, err := f.lookup(pth)
+	if err != nil {
+		return err
+	}
+	if sub, ok := fsn.(*mfs.Directory); ok && !recursive {
+		names, err := sub.ListNames(ctx)
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			return fmt.Errorf("%s: %w", pth, ErrDirNotEmpty)
+		}
+	}
+
+	if err := dir.Unlink(name); err != nil {
+		return err
+	}
+	return f.flush(ctx, dir)
+}
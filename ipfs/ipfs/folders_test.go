@@ -0,0 +1,48 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFoldersCpFetchesUnlocked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newTestPeer(t, ctx)
+	f, err := p.Folders(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err := p.StageFile(ctx, strings.NewReader("content nobody has"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := staged.Root.Cid()
+	staged.Discard()
+
+	// the copy waits for content that no peer has until it times out
+	cpCtx, cpCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cpCancel()
+	copied := make(chan error, 1)
+	go func() { copied <- f.Cp(cpCtx, "/ipfs/"+missing.String(), "/missing") }()
+	time.Sleep(200 * time.Millisecond)
+
+	changed := make(chan error, 1)
+	go func() { changed <- f.Mkdir(ctx, "/docs", false) }()
+	select {
+	case err := <-changed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-copied:
+		t.Fatal("copy of missing content ended before the timeout")
+	case <-time.After(time.Second):
+		t.Fatal("tree locked while the copy fetches its content")
+	}
+	if err := <-copied; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("copy error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
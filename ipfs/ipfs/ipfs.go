@@ -21,6 +21,7 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	ipld "github.com/ipfs/go-ipld-format"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/routing"
@@ -43,6 +44,10 @@ type Peer struct {
 	bstore          blockstore.Blockstore
 	bserv           blockservice.BlockService
 	reprovider      provider.System
+
+	pubsub   *pubsub.PubSub
+	topics   map[string]*pubsub.Topic
+	topicsMu sync.Mutex
 }
 
 func (p *Peer) GetHost() host.Host {
@@ -79,6 +84,11 @@ func New(
 		p.bserv.Close()
 		return nil, err
 	}
+	err = p.setupPubSub()
+	if err != nil {
+		p.bserv.Close()
+		return nil, err
+	}
 
 	go p.onClose()
 
@@ -119,6 +129,11 @@ func (p *Peer) Session(ctx context.Context) ipld.NodeGetter {
 }
 
 func (p *Peer) AddFile(ctx context.Context, r io.Reader) (ipld.Node, error) {
+	return p.addFile(ctx, r, p)
+}
+
+// addFile chunks r into a UnixFS DAG stored in dserv.
+func (p *Peer) addFile(ctx context.Context, r io.Reader, dserv ipld.DAGService) (ipld.Node, error) {
 	prefix, _ := merkledag.PrefixForCidVersion(1)
 
 	hashFunCode, _ := multihash.Names["sha2-256"]
@@ -126,7 +141,7 @@ func (p *Peer) AddFile(ctx context.Context, r io.Reader) (ipld.Node, error) {
 	prefix.MhLength = -1
 
 	dbp := helpers.DagBuilderParams{
-		Dagserv:    p,
+		Dagserv:    dserv,
 		RawLeaves:  true,
 		Maxlinks:   helpers.DefaultLinksPerBlock,
 		NoCopy:     false,
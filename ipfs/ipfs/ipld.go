@@ -0,0 +1,122 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mc "github.com/multiformats/go-multicodec"
+)
+
+// ErrPathNotFound is returned by DagGet when a path does not exist.
+var ErrPathNotFound = errors.New("path not found")
+
+// DecodeNode decodes data encoded with the given codec, e.g. dag-json or
+// dag-cbor, into an IPLD data model node.
+func DecodeNode(data []byte, codec mc.Code) (datamodel.Node, error) {
+	decoder, err := multicodec.LookupDecoder(uint64(codec))
+	if err != nil {
+		return nil, err
+	}
+
+	var nb datamodel.NodeBuilder
+	if codec == mc.DagPb {
+		nb = dagpb.Type.PBNode.NewBuilder()
+	} else {
+		nb = basicnode.Prototype.Any.NewBuilder()
+	}
+	if err := decoder(nb, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return nb.Build(), nil
+}
+
+// EncodeNode encodes an IPLD data model node with the given codec.
+func EncodeNode(n datamodel.Node, codec mc.Code) ([]byte, error) {
+	encoder, err := multicodec.LookupEncoder(uint64(codec))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encoder(n, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DagPut stores a node as a single block encoded with the given codec and
+// returns its CID. Links in the node become links between blocks.
+func (p *Peer) DagPut(ctx context.Context, n datamodel.Node, codec mc.Code) (cid.Cid, error) {
+	data, err := EncodeNode(n, codec)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	prefix := cid.Prefix{Version: 1, Codec: uint64(codec), MhType: uint64(mc.Sha2_256), MhLength: -1}
+	c, err := prefix.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	b, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return c, p.bserv.AddBlock(ctx, b)
+}
+
+// DagGet loads the block c and resolves the IPLD path from it, e.g.
+// "field/0/link", following links to other blocks along the way. A link at
+// the end of the path is returned as is.
+func (p *Peer) DagGet(ctx context.Context, c cid.Cid, path string) (datamodel.Node, error) {
+	n, err := p.loadNode(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := datamodel.ParsePath(path).Segments()
+	for i, seg := range segments {
+		if n.Kind() == datamodel.Kind_Link {
+			if n, err = p.followLink(ctx, n); err != nil {
+				return nil, err
+			}
+		}
+
+		next, err := n.LookupBySegment(seg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrPathNotFound, datamodel.NewPath(segments[:i+1]), err)
+		}
+		n = next
+	}
+	return n, nil
+}
+
+func (p *Peer) loadNode(ctx context.Context, c cid.Cid) (datamodel.Node, error) {
+	b, err := p.bserv.GetBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeNode(b.RawData(), mc.Code(c.Type()))
+}
+
+func (p *Peer) followLink(ctx context.Context, n datamodel.Node) (datamodel.Node, error) {
+	l, err := n.AsLink()
+	if err != nil {
+		return nil, err
+	}
+	cl, ok := l.(cidlink.Link)
+	if !ok {
+		return nil, fmt.Errorf("unsupported link type %T", l)
+	}
+	return p.loadNode(ctx, cl.Cid)
+}
@@ -0,0 +1,22 @@
+package ipfslite
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/namesys"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+)
+
+// IPNSName returns the IPNS name of the Peer, which is derived from the
+// key of its host.
+func (p *Peer) IPNSName() string {
+	return ipns.NameFromPeer(p.host.ID()).String()
+}
+
+// PublishIPNS points the IPNS name of the Peer at c, through the DHT.
+func (p *Peer) PublishIPNS(ctx context.Context, c cid.Cid) error {
+	key := p.host.Peerstore().PrivKey(p.host.ID())
+	return namesys.NewIPNSPublisher(p.dht, p.store).Publish(ctx, key, path.FromCid(c))
+}
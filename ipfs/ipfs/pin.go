@@ -0,0 +1,128 @@
+package ipfslite
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/blockservice"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// pins are recursive: a pinned CID keeps its whole DAG in the blockstore.
+func (p *Peer) pinStore() datastore.Datastore {
+	return namespace.Wrap(p.store, datastore.NewKey("/pins"))
+}
+
+// Pin fetches the whole DAG under c into the blockstore and records it as
+// pinned.
+func (p *Peer) Pin(ctx context.Context, c cid.Cid) error {
+	if err := merkledag.FetchGraph(ctx, c, p); err != nil {
+		return err
+	}
+	return p.addPin(ctx, c)
+}
+
+// addPin records c as pinned. The caller must make sure its DAG is local.
+func (p *Peer) addPin(ctx context.Context, c cid.Cid) error {
+	return p.pinStore().Put(ctx, datastore.NewKey(c.String()), nil)
+}
+
+// Unpin removes the pin on c. Blocks are kept until they are garbage
+// collected.
+func (p *Peer) Unpin(ctx context.Context, c cid.Cid) error {
+	return p.pinStore().Delete(ctx, datastore.NewKey(c.String()))
+}
+
+// IsPinned returns whether c is pinned.
+func (p *Peer) IsPinned(ctx context.Context, c cid.Cid) (bool, error) {
+	return p.pinStore().Has(ctx, datastore.NewKey(c.String()))
+}
+
+// Pins lists the pinned CIDs.
+func (p *Peer) Pins(ctx context.Context) ([]cid.Cid, error) {
+	res, err := p.pinStore().Query(ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var pins []cid.Cid
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			continue
+		}
+		pins = append(pins, c)
+	}
+	return pins, nil
+}
+
+// localDAG returns the blocks of the DAG under c held in the blockstore,
+// skipping the parts that are not.
+func (p *Peer) localDAG(ctx context.Context, c cid.Cid) ([]cid.Cid, error) {
+	dserv := merkledag.NewDAGService(blockservice.New(p.bstore, offline.Exchange(p.bstore)))
+
+	var cids []cid.Cid
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		nd, err := dserv.Get(ctx, c)
+		if ipld.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cids = append(cids, c)
+		return nd.Links(), nil
+	}
+	err := merkledag.Walk(ctx, getLinks, c, cid.NewSet().Visit)
+	return cids, err
+}
+
+// RemoveUnique deletes the local blocks of the unpinned DAG under c, except
+// those that a pinned DAG uses or that keep, given a multihash, asks to
+// keep. It returns the number of blocks deleted.
+func (p *Peer) RemoveUnique(ctx context.Context, c cid.Cid, keep func(mh string) bool) (int, error) {
+	if pinned, _ := p.IsPinned(ctx, c); pinned {
+		return 0, ErrPinned
+	}
+
+	pins, err := p.Pins(ctx)
+	if err != nil {
+		return 0, err
+	}
+	pinned := make(map[string]bool)
+	for _, pc := range pins {
+		cids, err := p.localDAG(ctx, pc)
+		if err != nil {
+			return 0, err
+		}
+		for _, bc := range cids {
+			pinned[string(bc.Hash())] = true
+		}
+	}
+
+	cids, err := p.localDAG(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, bc := range cids {
+		mh := string(bc.Hash())
+		if pinned[mh] || (keep != nil && keep(mh)) {
+			continue
+		}
+		if err := p.bstore.DeleteBlock(ctx, bc); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,77 @@
+package ipfslite
+
+import (
+	"context"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+)
+
+// setupPubSub attaches a GossipSub router to the Peer's host.
+func (p *Peer) setupPubSub() error {
+	// Use the DHT to find other subscribers of the topics we join, so
+	// nodes do not need to be connected beforehand to exchange messages.
+	disc := drouting.NewRoutingDiscovery(p.dht)
+	ps, err := pubsub.NewGossipSub(p.ctx, p.host, pubsub.WithDiscovery(disc))
+	if err != nil {
+		return err
+	}
+	p.pubsub = ps
+	p.topics = make(map[string]*pubsub.Topic)
+	return nil
+}
+
+// PubSub returns the underlying GossipSub router.
+func (p *Peer) PubSub() *pubsub.PubSub {
+	return p.pubsub
+}
+
+// Join returns a handle to the given topic. Topics are joined only once
+// and the same handle is returned on later calls, since the router does
+// not allow joining a topic twice.
+func (p *Peer) Join(topic string) (*pubsub.Topic, error) {
+	p.topicsMu.Lock()
+	defer p.topicsMu.Unlock()
+
+	if t, ok := p.topics[topic]; ok {
+		return t, nil
+	}
+
+	t, err := p.pubsub.Join(topic)
+	if err != nil {
+		return nil, err
+	}
+	p.topics[topic] = t
+	return t, nil
+}
+
+// Publish sends data to every peer subscribed to the topic.
+func (p *Peer) Publish(ctx context.Context, topic string, data []byte) error {
+	t, err := p.Join(topic)
+	if err != nil {
+		return err
+	}
+	return t.Publish(ctx, data)
+}
+
+// Subscribe returns a new subscription to the topic. Callers must Cancel
+// the subscription when they are done with it.
+func (p *Peer) Subscribe(topic string) (*pubsub.Subscription, error) {
+	t, err := p.Join(topic)
+	if err != nil {
+		return nil, err
+	}
+	return t.Subscribe()
+}
+
+// Topics lists the topics this Peer has joined.
+func (p *Peer) Topics() []string {
+	return p.pubsub.GetTopics()
+}
+
+// TopicPeers lists the peers we are connected to that are subscribed to
+// the topic.
+func (p *Peer) TopicPeers(topic string) []peer.ID {
+	return p.pubsub.ListPeers(topic)
+}
@@ -0,0 +1,123 @@
+package ipfslite
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// commitBatch is how many blocks of a staged file are stored at once.
+const commitBatch = 256
+
+// StagedFile is a file chunked into a DAG that is kept in a temporary
+// blockstore on disk, apart from the Peer's blockstore, until it is
+// committed. It lets callers inspect what a file would store before
+// storing it, without holding the file in memory. It must be discarded
+// once done with.
+type StagedFile struct {
+	Root  ipld.Node
+	dir   string
+	ds    *flatfs.Datastore
+	dserv ipld.DAGService
+	cids  []cid.Cid
+	sizes map[string]uint64
+}
+
+// StageFile chunks r like AddFile does, without storing any block.
+func (p *Peer) StageFile(ctx context.Context, r io.Reader) (*StagedFile, error) {
+	dir, err := os.MkdirTemp("", "ipfs-demo-stage-")
+	if err != nil {
+		return nil, err
+	}
+	ds, err := flatfs.CreateOrOpen(dir, flatfs.NextToLast(2), false)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	bs := blockstore.NewBlockstoreNoPrefix(ds) // flatfs keys have a single level
+	s := &StagedFile{
+		dir:   dir,
+		ds:    ds,
+		dserv: merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs))),
+		sizes: make(map[string]uint64),
+	}
+
+	s.Root, err = p.addFile(ctx, r, s.dserv)
+	if err != nil {
+		s.Discard()
+		return nil, err
+	}
+
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		nd, err := s.dserv.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		s.cids = append(s.cids, c)
+		s.sizes[string(c.Hash())] = uint64(len(nd.RawData()))
+		return nd.Links(), nil
+	}
+	if err := merkledag.Walk(ctx, getLinks, s.Root.Cid(), cid.NewSet().Visit); err != nil {
+		s.Discard()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Blocks returns the size of every unique block of the file, keyed by
+// multihash since that is what blocks are deduplicated on.
+func (s *StagedFile) Blocks() map[string]uint64 {
+	return s.sizes
+}
+
+// Discard deletes the temporary blockstore of a staged file.
+func (s *StagedFile) Discard() error {
+	s.ds.Close()
+	return os.RemoveAll(s.dir)
+}
+
+// Commit stores the blocks of a staged file in the Peer's blockstore.
+func (p *Peer) Commit(ctx context.Context, s *StagedFile) error {
+	batch := make([]ipld.Node, 0, commitBatch)
+	for _, c := range s.cids {
+		nd, err := s.dserv.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, nd)
+		if len(batch) == commitBatch {
+			if err := p.AddMany(ctx, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	return p.AddMany(ctx, batch)
+}
+
+// LocalBlocks returns the size of every unique block of the DAG under c,
+// keyed by multihash, without fetching anything from the network. It
+// fails with an ipld.ErrNotFound if part of the DAG is not local.
+func (p *Peer) LocalBlocks(ctx context.Context, c cid.Cid) (map[string]uint64, error) {
+	dserv := merkledag.NewDAGService(blockservice.New(p.bstore, offline.Exchange(p.bstore)))
+
+	sizes := make(map[string]uint64)
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		nd, err := dserv.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		sizes[string(c.Hash())] = uint64(len(nd.RawData()))
+		return nd.Links(), nil
+	}
+	err := merkledag.Walk(ctx, getLinks, c, cid.NewSet().Visit)
+	return sizes, err
+}
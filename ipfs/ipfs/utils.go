@@ -2,6 +2,8 @@ package ipfslite
 
 import (
 	"context"
+	crand "crypto/rand"
+	"fmt"
 	"io"
 	"math/rand"
 	"time"
@@ -38,28 +40,42 @@ func NewInMemoryDatastore() datastore.Batching {
 
 var connMgr, _ = connmgr.NewConnManager(100, 600, connmgr.WithGracePeriod(time.Minute))
 
+// NewIdentity generates the host key for a libp2p host. A non-zero seed
+// makes the key, and so the peer ID, deterministic across runs.
+func NewIdentity(seed int64) (crypto.PrivKey, error) {
+	var r io.Reader
+	if seed == 0 {
+		r = crand.Reader
+	} else {
+		r = rand.New(rand.NewSource(seed))
+	}
+
+	// Ed25519 keys are derived from the first 32 bytes read, unlike RSA
+	// keys whose generation is not deterministic for a given reader.
+	priv, _, err := crypto.GenerateEd25519Key(r)
+	return priv, err
+}
+
+// ListenAddrs returns the TCP and QUIC listen addresses on all interfaces
+// for the given port.
+func ListenAddrs(port int) []multiaddr.Multiaddr {
+	addr1, _ := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port))
+	addr2, _ := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", port))
+	return []multiaddr.Multiaddr{addr1, addr2}
+}
+
 func SetupLibp2p(
 	ctx context.Context,
+	hostKey crypto.PrivKey,
+	listenAddrs []multiaddr.Multiaddr,
 	ds datastore.Batching,
 ) (host.Host, *dualdht.DHT, error) {
 	var ddht *dualdht.DHT
 	var err error
 
-	var r io.Reader
-	r = rand.New(rand.NewSource(123))
-
-	priv, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, r)
-	if err != nil {
-		panic(err)
-	}
-
-	addr1, _ := multiaddr.NewMultiaddr("/ip4/0.0.0.0/tcp/4001")
-	addr2, _ := multiaddr.NewMultiaddr("/ip4/0.0.0.0/udp/4001/quic-v1")
-	addrs := []multiaddr.Multiaddr{addr1, addr2}
-
 	opts := []libp2p.Option{
-		libp2p.Identity(priv),
-		libp2p.ListenAddrs(addrs...),
+		libp2p.Identity(hostKey),
+		libp2p.ListenAddrs(listenAddrs...),
 		libp2p.ConnectionManager(connMgr),
 		libp2p.Security(libp2ptls.ID, libp2ptls.New),
 		libp2p.Security(noise.ID, noise.New),
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+)
+
+// KeyStore keeps the keys of encrypted files on local disk, one file per
+// CID, apart from the blocks and out of the shared catalog. Only nodes
+// holding a key can serve the plaintext of a file.
+type KeyStore struct {
+	dir string
+}
+
+func NewKeyStore(dir string) (*KeyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &KeyStore{dir: dir}, nil
+}
+
+func (ks *KeyStore) path(c cid.Cid) string {
+	return filepath.Join(ks.dir, c.String()+".key")
+}
+
+func (ks *KeyStore) Put(c cid.Cid, key []byte) error {
+	return os.WriteFile(ks.path(c), key, 0600)
+}
+
+// Get returns the key of c, or an error satisfying errors.Is(err,
+// os.ErrNotExist) when this node does not have it.
+func (ks *KeyStore) Get(c cid.Cid) ([]byte, error) {
+	return os.ReadFile(ks.path(c))
+}
+
+func (ks *KeyStore) Delete(c cid.Cid) error {
+	err := os.Remove(ks.path(c))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
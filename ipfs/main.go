@@ -1,141 +1,134 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	ipfslite "ipfs-demo/ipfs"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/ipfs/go-cid"
-	"github.com/rs/cors"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
-type FileInfo struct {
-	Filename string `json:"filename"`
-	CID      string `json:"cid"`
-	Size     int64  `json:"size"`
-	Type     string `json:"type"`
-}
-
 var (
 	ipfsNode      *ipfslite.Peer
+	catalog       *Catalog
+	pinTracker    *PinTracker
+	keyStore      *KeyStore
+	accountant    *Accountant
 	upgrader      = websocket.Upgrader{}
 	clients       = make(map[*websocket.Conn]bool) // Connected clients
-	broadcastChan = make(chan FileInfo)            // Channel for broadcasting file info
+	broadcastChan = make(chan any, 256)            // Channel for broadcasting file infos and events
 	mu            sync.Mutex                       // To manage access to clients map
 )
 
 func getFileFromNode(w http.ResponseWriter, r *http.Request) {
-	fileCid := r.PathValue("fileCid")
-	c, _ := cid.Decode(fileCid)
-	rsc, err := ipfsNode.GetFile(r.Context(), c)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	c, ok := pathCid(w, r)
+	if !ok {
 		return
 	}
+	serveFile(w, r, c)
+}
 
-	defer rsc.Close()
+// serveFile streams the content of c, under its catalog name and type if
+// it has a catalog entry.
+func serveFile(w http.ResponseWriter, r *http.Request, c cid.Cid) {
+	serveFileAdmitted(w, r, c, nil)
+}
 
-	// Set headers to force download
-	w.Header().Set("Content-Disposition", "attachment; filename="+fileCid)
-	w.Header().Set("Content-Type", "application/octet-stream")
+// serveFileAdmitted is serveFile with admit, when not nil, run once the
+// content is found and before it is sent. When admit returns false it has
+// written the response and nothing is sent.
+func serveFileAdmitted(w http.ResponseWriter, r *http.Request, c cid.Cid, admit func() bool) {
+	fileCid := c.String()
 
-	// Stream the file to the client
-	_, err = io.Copy(w, rsc)
-	if err != nil {
-		http.Error(w, "Failed to write file to response", http.StatusInternalServerError)
+	// Give up on finding the content after the retrieval timeout. Once its
+	// root is found the download may take as long as it needs.
+	ctx, cancel, ok := retrievalContext(w, r)
+	if !ok {
+		return
 	}
-}
-
-func getFileInfosHandler(w http.ResponseWriter, r *http.Request) {
-	file, err := os.Open("uploaded_files.txt")
-	if err != nil {
-		http.Error(w, "Could not open the file", http.StatusInternalServerError)
+	defer cancel()
+	if _, err := ipfsNode.Get(ctx, c); err != nil {
+		dagError(w, err)
 		return
 	}
-	defer file.Close()
-
-	var fileInfos []FileInfo
-	scanner := bufio.NewScanner(file)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.Split(line, ", ")
-		if len(parts) != 4 {
-			continue // Skip malformed lines
-		}
-
-		var filename, cid, fileType string
-		var size int64
 
-		for _, part := range parts {
-			kv := strings.SplitN(part, ": ", 2)
-			if len(kv) != 2 {
-				continue
-			}
-			switch kv[0] {
-			case "Filename":
-				filename = kv[1]
-			case "CID":
-				cid = kv[1]
-			case "Size":
-				fmt.Sscanf(kv[1], "%d", &size)
-			case "Type":
-				fileType = kv[1]
-			}
-		}
+	// Files uploaded through the API have a catalog entry with their name
+	// and type, other content is served under its CID
+	fileInfo, ferr := catalog.Get(r.Context(), fileCid)
+	if ferr != nil {
+		fileInfo = FileInfo{Filename: fileCid}
+	}
 
-		fileInfo := FileInfo{
-			Filename: filename,
-			CID:      cid,
-			Size:     size,
-			Type:     fileType,
+	var rsc io.ReadSeekCloser
+	var err error
+	if fileInfo.Encrypted {
+		// Decrypt on the fly with the key kept by this node
+		key, kerr := keyStore.Get(c)
+		if kerr != nil {
+			writeError(w, http.StatusForbidden, CodeForbidden, "File is encrypted and this node does not hold its key")
+			return
 		}
-		fileInfos = append(fileInfos, fileInfo)
+		rsc, err = ipfsNode.GetEncryptedFile(r.Context(), c, key)
+	} else {
+		rsc, err = ipfsNode.GetFile(r.Context(), c)
 	}
-
-	if err := scanner.Err(); err != nil {
-		http.Error(w, "Error reading the file", http.StatusInternalServerError)
+	if err != nil {
+		dagError(w, err)
 		return
 	}
 
-	// Set the content type to application/json
-	w.Header().Set("Content-Type", "application/json")
+	defer rsc.Close()
+	if admit != nil && !admit() {
+		return
+	}
 
-	// Return the file information as JSON
-	json.NewEncoder(w).Encode(fileInfos)
-}
+	// Download as an attachment unless inline display is asked for. Inline
+	// content is sandboxed so that HTML or SVG files cannot run scripts
+	// with the origin of the API.
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "true" {
+		disposition = "inline"
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, fileInfo.Filename))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
 
-func logFileInfo(filename, cid string, size int64, fileType string) error {
-	file, err := os.OpenFile("uploaded_files.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	// Without a usable stored type, ServeContent guesses it from the
+	// filename extension or else sniffs the first block of content
+	if fileInfo.Type != "" && fileInfo.Type != "application/octet-stream" {
+		w.Header().Set("Content-Type", fileInfo.Type)
 	}
-	defer file.Close()
 
-	_, err = fmt.Fprintf(file, "Filename: %s, CID: %s, Size: %d bytes, Type: %s\n", filename, cid, size, fileType)
-	return err
+	// Stream the file to the client, serving ranges when asked
+	http.ServeContent(w, r, fileInfo.Filename, time.Time{}, rsc)
 }
 
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the multipart form to handle file upload
 	err := r.ParseMultipartForm(10 << 20) // 10MB limit for file size
 	if err != nil {
-		http.Error(w, "Could not parse multipart form", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse multipart form")
 		return
 	}
 
 	// Get the files from the form data
 	files := r.MultipartForm.File["files"]
 	if files == nil {
-		http.Error(w, "No files uploaded", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "No files uploaded")
 		return
 	}
 
@@ -145,7 +138,7 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		// Open the uploaded file
 		file, err := fileHeader.Open()
 		if err != nil {
-			http.Error(w, "Error retrieving file from form", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Error retrieving file from form")
 			return
 		}
 		defer file.Close()
@@ -159,52 +152,259 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		// }
 		// defer tempFile.Close()
 
-		// Save file to IPFS
-		ipldNode, err := ipfsNode.AddFile(r.Context(), file)
+		// Describe the file, with the description and tags given along
+		// with the files
+		fileInfo := FileInfo{
+			Filename:    fileHeader.Filename,
+			Type:        fileHeader.Header.Get("Content-Type"),
+			Encrypted:   *encryptUploads || r.FormValue("encrypt") == "true",
+			Description: strings.TrimSpace(r.FormValue("description")),
+			Tags:        splitTags(r.FormValue("tags")),
+		}
+		if problems := validateMetadata(fileInfo); len(problems) > 0 {
+			writeErrorDetails(w, http.StatusUnprocessableEntity, CodeUnprocessable, "Invalid metadata", problems)
+			return
+		}
+		if strings.HasPrefix(fileInfo.Filename, s3FilenamePrefix) {
+			writeErrorDetails(w, http.StatusUnprocessableEntity, CodeUnprocessable, "Invalid filename",
+				map[string]string{"filename": fmt.Sprintf("names starting with %s are kept for S3 objects", s3FilenamePrefix)})
+			return
+		}
+
+		fileInfo, err = importFile(r.Context(), principalFrom(r.Context()), fileInfo, file)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error saving file to IPFS: %s", err.Error()), http.StatusInternalServerError)
+			quotaError(w, err)
 			return
 		}
+		fileInfos = append(fileInfos, fileInfo)
+	}
 
-		fmt.Printf("saved a file with cid: %s", ipldNode.Cid().String())
+	// Set the content type to application/json
+	w.Header().Set("Content-Type", "application/json")
 
-		// Gather file information
-		fileSize := fileHeader.Size
-		fileType := fileHeader.Header.Get("Content-Type")
+	// Return the file information as JSON
+	json.NewEncoder(w).Encode(fileInfos)
+}
 
-		// Create FileInfo struct
-		fileInfo := FileInfo{
-			Filename: fileHeader.Filename,
-			CID:      ipldNode.Cid().String(),
-			Size:     fileSize,
-			Type:     fileType,
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// importFile adds content to the node and the catalog on behalf of
+// principal, as the next version of fileInfo.Filename. fileInfo gives the
+// name, type, metadata and whether to encrypt; the rest is filled in. The
+// file is only stored if it fits in the quotas of the principal, and is
+// then replicated to the cluster. Content that is already the latest
+// version of the filename returns that version unchanged. Errors are
+// QuotaErrors, errVersionExists when a concurrent upload took the version,
+// or internal.
+func importFile(ctx context.Context, principal *Principal, fileInfo FileInfo, r io.Reader) (FileInfo, error) {
+	// Chunk the file, encrypted with a new key if asked, without storing
+	// it until it is known to fit in the quotas
+	counter := &countingReader{r: r}
+	var content io.Reader = counter
+	var key []byte
+	var err error
+	if fileInfo.Encrypted {
+		key, err = ipfslite.NewEncryptionKey()
+		if err != nil {
+			return fileInfo, fmt.Errorf("creating encryption key: %w", err)
+		}
+		content, err = ipfslite.EncryptReader(content, key)
+		if err != nil {
+			return fileInfo, fmt.Errorf("encrypting file: %w", err)
 		}
+	}
+	staged, err := ipfsNode.StageFile(ctx, content)
+	if err != nil {
+		return fileInfo, err
+	}
+	defer staged.Discard()
+	ipldNode := staged.Root
+
+	fileInfo.CID = ipldNode.Cid().String()
+	fileInfo.Size = counter.n
+	fileInfo.Origin = ipfsNode.GetHost().ID().String()
+	fileInfo.Owner = principal.User
+	fileInfo.Groups = principal.Groups
+	fileInfo.UploadedAt = time.Now().UTC()
+
+	unchanged := false
+	err = accountant.Admit(ctx, principal, staged, func() error {
+		if err := ipfsNode.Commit(ctx, staged); err != nil {
+			return err
+		}
+		fmt.Printf("saved a file with cid: %s", ipldNode.Cid().String())
 
-		fileInfos = append(fileInfos, fileInfo)
+		if fileInfo.Encrypted {
+			if err := keyStore.Put(ipldNode.Cid(), key); err != nil {
+				return fmt.Errorf("saving encryption key: %w", err)
+			}
+		}
+
+		// A new upload of a filename is its next version, unless it has
+		// the content of the latest version already
+		versions, err := catalog.Versions(ctx, fileInfo.Filename)
+		if err != nil {
+			return fmt.Errorf("numbering version: %w", err)
+		}
+		if n := len(versions); n > 0 && versions[n-1].CID == fileInfo.CID {
+			fileInfo = versions[n-1]
+			unchanged = true
+			return nil
+		}
+		fileInfo.Version = nextVersion(versions)
 
-		// Log file info to text file
-		if err := logFileInfo(fileHeader.Filename, ipldNode.Cid().String(), fileSize, fileType); err != nil {
+		// Log file info to the catalog
+		added, err := catalog.Add(ctx, fileInfo)
+		if err != nil {
 			fmt.Printf("error while logging file info: %s\n", err.Error())
-			http.Error(w, "Error logging file info", http.StatusInternalServerError)
+			return fmt.Errorf("logging file info: %w", err)
+		}
+		if !added {
+			return fmt.Errorf("%w: version %d of %s", errVersionExists, fileInfo.Version, fileInfo.Filename)
+		}
+		return nil
+	})
+	if err != nil || unchanged {
+		return fileInfo, err
+	}
+
+	broadcastChan <- fileInfo
+
+	// Replicate the file to other nodes of the cluster
+	_, err = pinTracker.Add(ctx, ipldNode.Cid(), *defaultReplicationMin, *defaultReplicationMax, fileInfo.Filename)
+	if err != nil {
+		fmt.Printf("error while pinning %s: %s\n", fileInfo.CID, err.Error())
+	}
+	if err := pruneVersions(ctx, fileInfo.Filename, fileInfo.Owner); err != nil {
+		fmt.Printf("error pruning versions of %s: %s\n", fileInfo.Filename, err.Error())
+	}
+	return fileInfo, nil
+}
+
+type DeleteResult struct {
+	CID           string `json:"cid"`
+	BlocksRemoved int    `json:"blocksRemoved"`
+}
+
+// Remove a file from the catalog, with every version and name that has its
+// content, and unpin it across the cluster once no entry has it. With
+// gc=true, the blocks that no other file uses are also deleted from this
+// node right away. Users remove their own entries of the content, admins
+// every entry.
+func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+	gc := false
+	if v := r.URL.Query().Get("gc"); v != "" {
+		var err error
+		if gc, err = strconv.ParseBool(v); err != nil {
+			invalidParameter(w, "gc", v)
+			return
+		}
+	}
+
+	entries, err := catalog.Entries(r.Context(), c.String())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading the catalog")
+		return
+	}
+	if len(entries) == 0 {
+		writeError(w, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+	// owners remove their own entries, which leaves the content of other
+	// users in place
+	principal := principalFrom(r.Context())
+	if !principal.HasRole(RoleAdmin) {
+		entries = slices.DeleteFunc(entries, func(fileInfo FileInfo) bool { return fileInfo.Owner != principal.User })
+	}
+	if len(entries) == 0 {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Only the owner of a file or an admin can delete it")
+		return
+	}
+
+	for _, fileInfo := range entries {
+		if _, err := removeFile(r.Context(), fileInfo); err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error removing catalog entry: %s", err.Error()))
 			return
 		}
+	}
+	// the content is kept as long as any entry has it, whichever removal
+	// saw the last one go
+	remaining, err := catalog.Entries(r.Context(), c.String())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading the catalog")
+		return
+	}
+	kept := len(remaining) > 0
 
-		broadcastChan <- fileInfo
+	result := DeleteResult{CID: c.String()}
+	if gc && !kept {
+		// unpin now rather than waiting for the pin tracker
+		if err := ipfsNode.Unpin(r.Context(), c); err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error unpinning: %s", err.Error()))
+			return
+		}
+		result.BlocksRemoved, err = accountant.Collect(r.Context(), c)
+		if err != nil {
+			dagError(w, err)
+			return
+		}
 	}
+	writeJSON(w, result)
+}
 
-	// Set the content type to application/json
-	w.Header().Set("Content-Type", "application/json")
+// removeFile removes a file version from the catalog of the cluster. Once
+// no entry has its content, it unpins it everywhere and deletes its key.
+// Its blocks are left for garbage collection. It reports whether the
+// content was kept because other entries still have it, in which case it
+// stays pinned and must not be collected.
+func removeFile(ctx context.Context, fileInfo FileInfo) (bool, error) {
+	if err := catalog.Remove(ctx, fileInfo); err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		return false, err
+	}
+	remaining, err := catalog.Entries(ctx, fileInfo.CID)
+	if err != nil {
+		return false, err
+	}
+	if len(remaining) > 0 {
+		return true, nil
+	}
+	broadcastChan <- FileEvent{Event: FileDeleted, CID: fileInfo.CID}
 
-	// Return the file information as JSON
-	json.NewEncoder(w).Encode(fileInfos)
+	c, err := cid.Decode(fileInfo.CID)
+	if err != nil {
+		return false, err
+	}
+	if err := pinTracker.Remove(ctx, c); err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		fmt.Printf("error while unpinning %s: %s\n", fileInfo.CID, err.Error())
+	}
+	// Without its key an encrypted file cannot be read back, even from
+	// blocks that other nodes still hold
+	if fileInfo.Encrypted {
+		if err := keyStore.Delete(c); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("error while deleting key of %s: %s\n", fileInfo.CID, err.Error())
+		}
+	}
+	return false, nil
 }
 
 func wsHandler(w http.ResponseWriter, r *http.Request) {
-	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		http.Error(w, "Could not upgrade to websocket", http.StatusInternalServerError)
-		return
+		return // the upgrader has replied
 	}
 	fmt.Println("a websocket connected")
 	defer conn.Close()
@@ -243,7 +443,6 @@ func broadcastFiles() {
 
 func setUpFolders() {
 	// Erase data on start
-	os.RemoveAll("uploaded_files.txt")
 	os.RemoveAll("./uploads")
 
 	err := os.MkdirAll("./uploads", os.ModePerm)
@@ -251,23 +450,52 @@ func setUpFolders() {
 		fmt.Printf("Error creating uploads directory: %s", err.Error())
 		return
 	}
-
-	f, err := os.OpenFile("./uploaded_files.txt", os.O_CREATE, os.ModePerm)
-	if err != nil {
-		fmt.Printf("Error creating uploaded_files: %s", err.Error())
-		return
-	}
-	f.Close()
 }
 
 func main() {
+	flag.Parse()
 	setUpFolders()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	var err error
+	keyStore, err = NewKeyStore(*keyDir)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := setUpAuth(); err != nil {
+		panic(err)
+	}
+
+	if err := parseRateLimits(*rateLimitsFlag); err != nil {
+		panic(err)
+	}
+
+	originPolicy := newOriginPolicy()
+	upgrader.CheckOrigin = originPolicy.CheckOrigin
+	upgrader.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		code := CodeBadRequest
+		if status == http.StatusForbidden {
+			code = CodeForbidden
+		}
+		writeError(w, status, code, fmt.Sprintf("Could not upgrade to websocket: %s", reason.Error()))
+	}
+
+	quotas, err := LoadQuotas(*quotasFile)
+	if err != nil {
+		panic(err)
+	}
+	accountant = NewAccountant(quotas)
+
+	hostKey, err := ipfslite.NewIdentity(*identitySeed)
+	if err != nil {
+		panic(err)
+	}
+
 	ds := ipfslite.NewInMemoryDatastore()
-	host, dht, err := ipfslite.SetupLibp2p(ctx, ds)
+	host, dht, err := ipfslite.SetupLibp2p(ctx, hostKey, ipfslite.ListenAddrs(*p2pPort), ds)
 	if err != nil {
 		panic(err)
 	}
@@ -277,21 +505,111 @@ func main() {
 		panic(err)
 	}
 
+	catalog, err = setUpCatalog(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	pinTracker, err = setUpPinTracker(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	shares, err = setUpShares(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	folders, err = setUpFolderTree(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	if *s3Addr != "" {
+		s3Store, err = setUpS3(ctx)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	fmt.Printf("ipfs node run with id (%s), addr: %v\n", ipfsNode.GetHost().ID(), ipfsNode.GetHost().Addrs())
-	go ipfsNode.Bootstrap(ipfslite.DefaultBootstrapPeers())
+	bootstrapPeers := ipfslite.DefaultBootstrapPeers()
+	if *clusterPeers != "" {
+		peers, err := peer.AddrInfosFromP2pAddrs(parseMultiaddrs(*clusterPeers)...)
+		if err != nil {
+			panic(err)
+		}
+		bootstrapPeers = append(bootstrapPeers, peers...)
+	}
+	go ipfsNode.Bootstrap(bootstrapPeers)
 
 	go broadcastFiles()
+	go pruneLoop(ctx)
 
 	// Set up the HTTP server and upload route
 	mux := http.NewServeMux()
-	mux.HandleFunc("/upload", uploadHandler)
-	mux.HandleFunc("/files", getFileInfosHandler)
-	mux.HandleFunc("/files/{fileCid}", getFileFromNode)
-	mux.HandleFunc("/socket", wsHandler)
-	handler := cors.Default().Handler(mux)
-
-	fmt.Println("Starting server on :8000...")
-	if err := http.ListenAndServe(":8000", handler); err != nil {
+	limit := rateLimited()
+	mux.HandleFunc("/upload", limit("upload", requireRole(RoleUpload, uploadHandler)))
+	mux.HandleFunc("/files", limit("api", requireRole(RoleRead, getFileInfosHandler)))
+	mux.HandleFunc("/files/{cid}", limit("download", requireRole(RoleRead, getFileFromNode)))
+	mux.HandleFunc("DELETE /files/{cid}", limit("api", requireRole(RoleUpload, deleteFileHandler)))
+	mux.HandleFunc("PATCH /files/{cid}/metadata", limit("api", requireRole(RoleUpload, updateMetadataHandler)))
+	mux.HandleFunc("GET /versions/{filename}", limit("api", requireRole(RoleRead, getVersionsHandler)))
+	mux.HandleFunc("GET /versions/{filename}/diff", limit("api", requireRole(RoleRead, diffVersionsHandler)))
+	mux.HandleFunc("GET /versions/{filename}/{version}", limit("download", requireRole(RoleRead, getVersionHandler)))
+	mux.HandleFunc("POST /versions/{filename}/{version}/restore", limit("api", requireRole(RoleUpload, restoreVersionHandler)))
+	mux.HandleFunc("POST /shares", limit("api", requireRole(RoleUpload, createShareHandler)))
+	mux.HandleFunc("GET /shares", limit("api", requireRole(RoleUpload, getSharesHandler)))
+	mux.HandleFunc("DELETE /shares/{id}", limit("api", requireRole(RoleUpload, revokeShareHandler)))
+	mux.HandleFunc("GET /s/{token}", limit("download", shareDownloadHandler))
+	mux.HandleFunc("/dav/", davHandler(limit))
+	mux.HandleFunc("/socket", limit("api", requireRole(RoleRead, wsHandler)))
+	mux.HandleFunc("GET /usage", limit("api", requireRole(RoleRead, getUsageHandler)))
+	mux.HandleFunc("GET /usage/users/{user}", limit("api", requireRole(RoleAdmin, getUserUsageHandler)))
+	mux.HandleFunc("GET /usage/groups/{group}", limit("api", requireRole(RoleAdmin, getGroupUsageHandler)))
+	mux.HandleFunc("GET /folders", limit("api", requireRole(RoleRead, getFolderRootHandler)))
+	mux.HandleFunc("GET /folders/ls", limit("api", requireRole(RoleRead, lsFolderHandler)))
+	mux.HandleFunc("GET /folders/stat", limit("api", requireRole(RoleRead, statFolderHandler)))
+	mux.HandleFunc("POST /folders/mkdir", limit("api", requireRole(RoleUpload, mkdirFolderHandler)))
+	mux.HandleFunc("POST /folders/cp", limit("api", requireRole(RoleUpload, cpFolderHandler)))
+	mux.HandleFunc("POST /folders/mv", limit("api", requireRole(RoleUpload, mvFolderHandler)))
+	mux.HandleFunc("POST /folders/rm", limit("api", requireRole(RoleUpload, rmFolderHandler)))
+	mux.HandleFunc("GET /catalog/status", limit("api", requireRole(RoleRead, getCatalogStatusHandler)))
+	mux.HandleFunc("POST /catalog/repair", limit("api", requireRole(RoleAdmin, repairCatalogHandler)))
+	mux.HandleFunc("GET /pins", limit("api", requireRole(RoleRead, getPinsHandler)))
+	mux.HandleFunc("GET /pins/{cid}", limit("api", requireRole(RoleRead, getPinHandler)))
+	mux.HandleFunc("POST /pins/{cid}", limit("api", requireRole(RoleAdmin, addPinHandler)))
+	mux.HandleFunc("DELETE /pins/{cid}", limit("api", requireRole(RoleAdmin, removePinHandler)))
+	mux.HandleFunc("POST /fetch/{cid}", limit("download", requireRole(RoleAdmin, fetchHandler)))
+	mux.HandleFunc("GET /dag/{cid}/stat", limit("api", requireRole(RoleRead, dagStatHandler)))
+	mux.HandleFunc("GET /dag/{cid}/links", limit("api", requireRole(RoleRead, dagLinksHandler)))
+	mux.HandleFunc("GET /ls/{cid}", limit("api", requireRole(RoleRead, lsHandler)))
+	mux.HandleFunc("POST /blocks", limit("upload", requireRole(RoleAdmin, blockPutHandler)))
+	mux.HandleFunc("GET /blocks/{cid}", limit("download", requireRole(RoleRead, blockGetHandler)))
+	mux.HandleFunc("GET /blocks/{cid}/stat", limit("api", requireRole(RoleRead, blockStatHandler)))
+	mux.HandleFunc("DELETE /blocks/{cid}", limit("api", requireRole(RoleAdmin, blockRmHandler)))
+	mux.HandleFunc("GET /cid/{cid}", limit("api", cidInspectHandler))
+	mux.HandleFunc("POST /ipld", limit("upload", requireRole(RoleAdmin, dagPutHandler)))
+	mux.HandleFunc("GET /ipld/{cid}", limit("api", requireRole(RoleRead, dagGetHandler)))
+	mux.HandleFunc("GET /ipld/{cid}/{path...}", limit("api", requireRole(RoleRead, dagGetHandler)))
+	mux.HandleFunc("GET /peers", limit("api", requireRole(RoleRead, getPeersHandler)))
+	mux.HandleFunc("GET /pubsub/topics", limit("api", requireRole(RoleRead, getTopicsHandler)))
+	mux.HandleFunc("POST /pubsub/{topic}", limit("api", requireRole(RoleUpload, publishHandler)))
+	mux.HandleFunc("GET /pubsub/{topic}/peers", limit("api", requireRole(RoleRead, getTopicPeersHandler)))
+	mux.HandleFunc("GET /pubsub/{topic}/socket", limit("api", requireRole(RoleUpload, topicSocketHandler)))
+	handler := originPolicy.Handler(mux)
+
+	if *s3Addr != "" {
+		go func() {
+			fmt.Printf("Starting S3 server on %s...\n", *s3Addr)
+			if err := listenAndServe(*s3Addr, s3Handler(limit)); err != nil {
+				fmt.Printf("Error starting S3 server: %s\n", err.Error())
+			}
+		}()
+	}
+
+	fmt.Printf("Starting server on %s...\n", *httpAddr)
+	if err := listenAndServe(*httpAddr, handler); err != nil {
 		fmt.Printf("Error starting server: %s", err.Error())
 	}
 }
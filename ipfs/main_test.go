@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDeleteFileOwnership(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      string
+		roles     []string
+		status    int
+		remaining []string // owners of the entries left, by filename
+		collected bool     // whether gc=true removed blocks
+	}{
+		{"owner removes own entries", "alice", nil, http.StatusOK, []string{"bob"}, false},
+		{"other owner removes own entry", "bob", nil, http.StatusOK, []string{"alice", "alice"}, false},
+		{"user without entries", "carol", nil, http.StatusForbidden, []string{"alice", "bob", "alice"}, false},
+		{"admin removes every entry", "root", []string{RoleAdmin}, http.StatusOK, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestCatalog(t)
+			var err error
+			if pinTracker, err = setUpPinTracker(ctx); err != nil {
+				t.Fatal(err)
+			}
+			accountant = NewAccountant(&Quotas{users: make(map[string]Quota), groups: make(map[string]Quota)})
+			const content = "content uploaded by both"
+			fileInfo := addTestFile(t, ctx, FileInfo{Filename: "a.txt", Owner: "alice"}, content)
+			addTestFile(t, ctx, FileInfo{Filename: "b.txt", Owner: "bob"}, content)
+			addTestFile(t, ctx, FileInfo{Filename: "c.txt", Owner: "alice"}, content)
+
+			principal := &Principal{User: tt.user, Roles: append([]string{RoleUpload}, tt.roles...)}
+			r := httptest.NewRequest(http.MethodDelete, "/files/"+fileInfo.CID+"?gc=true", nil)
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+			r.SetPathValue("cid", fileInfo.CID)
+			w := httptest.NewRecorder()
+			deleteFileHandler(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			entries, err := catalog.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.SortFunc(entries, func(a, b FileInfo) int { return strings.Compare(a.Filename, b.Filename) })
+			var owners []string
+			for _, entry := range entries {
+				owners = append(owners, entry.Owner)
+			}
+			if !slices.Equal(owners, tt.remaining) {
+				t.Errorf("owners of the entries left = %v, want %v", owners, tt.remaining)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var result DeleteResult
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if (result.BlocksRemoved > 0) != tt.collected {
+				t.Errorf("blocks removed = %d, want some: %v", result.BlocksRemoved, tt.collected)
+			}
+		})
+	}
+}
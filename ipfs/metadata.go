@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ipfs/go-datastore"
+)
+
+// Limits on the metadata of a file, which is replicated with the catalog.
+const (
+	maxTags           = 32
+	maxTagLength      = 64
+	maxDescription    = 4096
+	maxMetadataKeys   = 64
+	maxMetadataKey    = 128
+	maxMetadataValue  = 1024
+	maxMetadataUpdate = 64 << 10
+)
+
+// MetadataUpdate is the body of PATCH /files/{cid}/metadata. Fields left
+// out are unchanged. Tags replaces all the tags, while AddTags and
+// RemoveTags edit them. Metadata is merged into the existing keys, and a
+// null value removes a key.
+type MetadataUpdate struct {
+	Description *string            `json:"description"`
+	Tags        []string           `json:"tags"`
+	AddTags     []string           `json:"addTags"`
+	RemoveTags  []string           `json:"removeTags"`
+	Metadata    map[string]*string `json:"metadata"`
+}
+
+// normalizeTag trims and lowercases a tag, so that tags match whatever
+// case they are written in.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// splitTags parses a comma-separated list of tags.
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = normalizeTag(tag); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Apply edits the metadata of a catalog entry.
+func (u *MetadataUpdate) Apply(fileInfo *FileInfo) {
+	if u.Description != nil {
+		fileInfo.Description = strings.TrimSpace(*u.Description)
+	}
+
+	if u.Tags != nil {
+		fileInfo.Tags = nil
+	}
+	for _, tag := range append(u.Tags, u.AddTags...) {
+		if tag = normalizeTag(tag); tag != "" && !slices.Contains(fileInfo.Tags, tag) {
+			fileInfo.Tags = append(fileInfo.Tags, tag)
+		}
+	}
+	for _, tag := range u.RemoveTags {
+		fileInfo.Tags = slices.DeleteFunc(fileInfo.Tags, func(t string) bool { return t == normalizeTag(tag) })
+	}
+
+	for key, value := range u.Metadata {
+		if value == nil {
+			delete(fileInfo.Metadata, key)
+			continue
+		}
+		if fileInfo.Metadata == nil {
+			fileInfo.Metadata = make(map[string]string)
+		}
+		fileInfo.Metadata[key] = *value
+	}
+	if len(fileInfo.Metadata) == 0 {
+		fileInfo.Metadata = nil
+	}
+}
+
+// validateMetadata checks the metadata of an entry against the limits. It
+// returns the problems found, keyed by field.
+func validateMetadata(fileInfo FileInfo) map[string]string {
+	problems := make(map[string]string)
+	if len(fileInfo.Description) > maxDescription {
+		problems["description"] = fmt.Sprintf("longer than %d bytes", maxDescription)
+	}
+	if len(fileInfo.Tags) > maxTags {
+		problems["tags"] = fmt.Sprintf("more than %d tags", maxTags)
+	}
+	for _, tag := range fileInfo.Tags {
+		if len(tag) > maxTagLength || strings.Contains(tag, ",") {
+			problems["tags"] = fmt.Sprintf("tag %q is longer than %d bytes or contains a comma", tag, maxTagLength)
+		}
+	}
+	if len(fileInfo.Metadata) > maxMetadataKeys {
+		problems["metadata"] = fmt.Sprintf("more than %d keys", maxMetadataKeys)
+	}
+	for key, value := range fileInfo.Metadata {
+		if key == "" || len(key) > maxMetadataKey || len(value) > maxMetadataValue {
+			problems["metadata"] = fmt.Sprintf("key %q is empty or longer than %d bytes, or its value is longer than %d bytes",
+				key, maxMetadataKey, maxMetadataValue)
+		}
+	}
+	return problems
+}
+
+// Edit the description, tags and metadata of a file, in the latest entry
+// with its content. Users can edit their own files, admins any file. The
+// updated entry is returned, and pushed to the websocket clients of every
+// node.
+func updateMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+
+	var update MetadataUpdate
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxMetadataUpdate))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid metadata update: %s", err.Error()))
+		return
+	}
+
+	fileInfo, err := catalog.Get(r.Context(), c.String())
+	if errors.Is(err, datastore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading the catalog")
+		return
+	}
+	principal := principalFrom(r.Context())
+	if fileInfo.Owner != principal.User && !principal.HasRole(RoleAdmin) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Only the owner of a file or an admin can edit it")
+		return
+	}
+
+	update.Apply(&fileInfo)
+	if problems := validateMetadata(fileInfo); len(problems) > 0 {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, CodeUnprocessable, "Invalid metadata", problems)
+		return
+	}
+
+	if err := catalog.Update(r.Context(), fileInfo); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error updating the catalog: %s", err.Error()))
+		return
+	}
+	broadcastChan <- fileInfo
+
+	writeJSON(w, fileInfo)
+}
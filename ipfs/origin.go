@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+// OriginPolicy decides which browser origins may use the API. It is shared
+// by the CORS handler and the websocket upgrader, which browsers do not
+// subject to CORS.
+type OriginPolicy struct {
+	// Origins are exact origins such as https://app.example.com, or
+	// patterns with a * wildcard such as https://*.example.com. A lone *
+	// allows any origin.
+	Origins []string
+	Methods []string
+	Headers []string
+	// Credentials lets browsers send cookies and Authorization headers
+	// along with cross-origin requests.
+	Credentials bool
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func newOriginPolicy() *OriginPolicy {
+	return &OriginPolicy{
+		Origins:     splitList(*corsOrigins),
+		Methods:     splitList(*corsMethods),
+		Headers:     splitList(*corsHeaders),
+		Credentials: *corsCredentials,
+	}
+}
+
+// Allowed reports whether origin is on the list.
+func (p *OriginPolicy) Allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.Origins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok &&
+			len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *OriginPolicy) allowOrigin(origin string) bool {
+	if p.Allowed(origin) {
+		return true
+	}
+	fmt.Printf("rejected request from origin %q: not in -cors-origins\n", origin)
+	return false
+}
+
+// CheckOrigin reports whether a request may be served, and is also the
+// check of the websocket upgrader. Requests without an Origin header do not
+// come from a browser and are let through, as are requests from the origin
+// of the API itself.
+func (p *OriginPolicy) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return p.allowOrigin(origin)
+}
+
+// Handler wraps next with the CORS handling of the policy. Requests from
+// origins not on the list are refused rather than only left without CORS
+// headers, so that simple requests such as form uploads cannot be made
+// from other sites either.
+func (p *OriginPolicy) Handler(next http.Handler) http.Handler {
+	c := cors.New(cors.Options{
+		AllowOriginFunc:  p.Allowed,
+		AllowedMethods:   p.Methods,
+		AllowedHeaders:   p.Headers,
+		ExposedHeaders:   []string{"Retry-After", "Content-Disposition"},
+		AllowCredentials: p.Credentials,
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.CheckOrigin(r) {
+			writeErrorDetails(w, http.StatusForbidden, CodeForbidden, "Origin not allowed",
+				map[string]string{"origin": r.Header.Get("Origin")})
+			return
+		}
+		c.ServeHTTP(w, r, next.ServeHTTP)
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PeerInfo is a peer this node is connected to.
+type PeerInfo struct {
+	ID      string   `json:"id"`
+	Addrs   []string `json:"addrs"`
+	Cluster bool     `json:"cluster,omitempty"` // member of the cluster of this node
+}
+
+// List the peers this node is connected to, only the members of its
+// cluster with cluster=true.
+func getPeersHandler(w http.ResponseWriter, r *http.Request) {
+	clusterOnly, ok := boolParam(w, r, "cluster")
+	if !ok {
+		return
+	}
+
+	members := make(map[peer.ID]bool)
+	for _, p := range clusterMembers() {
+		members[p] = true
+	}
+
+	network := ipfsNode.GetHost().Network()
+	peers := []PeerInfo{}
+	for _, p := range network.Peers() {
+		if clusterOnly && !members[p] {
+			continue
+		}
+		info := PeerInfo{ID: p.String(), Addrs: []string{}, Cluster: members[p]}
+		for _, conn := range network.ConnsToPeer(p) {
+			info.Addrs = append(info.Addrs, conn.RemoteMultiaddr().String())
+		}
+		peers = append(peers, info)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	writeJSON(w, peers)
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	ipfslite "ipfs-demo/ipfs"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	pinTimeout        = 10 * time.Minute
+	reconcileInterval = time.Minute
+)
+
+const (
+	PinStatusPinning = "pinning"
+	PinStatusPinned  = "pinned"
+	PinStatusFailed  = "failed"
+)
+
+// PinIntent asks the cluster to keep a CID pinned on between
+// ReplicationMin and ReplicationMax nodes. Allocations lists the peers
+// that should pin it.
+type PinIntent struct {
+	CID            string    `json:"cid"`
+	Name           string    `json:"name,omitempty"`
+	ReplicationMin int       `json:"replicationMin"`
+	ReplicationMax int       `json:"replicationMax"`
+	Allocations    []string  `json:"allocations"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// PinStatus is the state of a pin on one peer.
+type PinStatus struct {
+	Peer      string    `json:"peer"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type PinInfo struct {
+	PinIntent
+	Peers []PinStatus `json:"peers"`
+	// Replicas counts the allocated peers that have pinned the CID or are
+	// pinning it. The pin is under-replicated while it is below
+	// ReplicationMin, until the cluster allocates more peers.
+	Replicas        int  `json:"replicas"`
+	UnderReplicated bool `json:"underReplicated"`
+}
+
+// pinInfo describes an intent with the statuses reported for its CID.
+func pinInfo(intent PinIntent, statuses []PinStatus) PinInfo {
+	info := PinInfo{PinIntent: intent, Peers: []PinStatus{}}
+	if statuses != nil {
+		info.Peers = statuses
+	}
+	info.Replicas = len(intent.replicas(statuses))
+	info.UnderReplicated = info.Replicas < intent.ReplicationMin
+	return info
+}
+
+// replicas returns the allocated peers that have pinned the CID of the
+// intent or are pinning it.
+func (intent PinIntent) replicas(statuses []PinStatus) map[string]bool {
+	replicas := make(map[string]bool)
+	for _, s := range statuses {
+		if (s.Status == PinStatusPinned || s.Status == PinStatusPinning) && slices.Contains(intent.Allocations, s.Peer) {
+			replicas[s.Peer] = true
+		}
+	}
+	return replicas
+}
+
+// PinTracker shares pin intents between the nodes of the cluster and pins
+// the ones allocated to this node. Each node only writes its own status
+// entries, so statuses never conflict.
+type PinTracker struct {
+	intents *ipfslite.CRDT
+	status  *ipfslite.CRDT
+
+	mu      sync.Mutex
+	pinning map[string]bool
+}
+
+func pinsTopic() string {
+	return "ipfs-demo/pins/" + *clusterName
+}
+
+func pinStatusTopic() string {
+	return "ipfs-demo/pinstatus/" + *clusterName
+}
+
+func setUpPinTracker(ctx context.Context) (*PinTracker, error) {
+	t := &PinTracker{pinning: make(map[string]bool)}
+
+	var err error
+	t.intents, err = ipfsNode.NewCRDT(ctx, "pins", pinsTopic(), &ipfslite.CRDTOptions{
+		PutHook: func(key string, value []byte) {
+			var intent PinIntent
+			if err := json.Unmarshal(value, &intent); err != nil {
+				return
+			}
+			go t.track(ctx, intent)
+		},
+		DeleteHook: func(key string) {
+			go t.untrack(ctx, key)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t.status, err = ipfsNode.NewCRDT(ctx, "pinstatus", pinStatusTopic(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	go t.reconcile(ctx)
+	return t, nil
+}
+
+// Add creates a pin intent for c and allocates it to cluster peers.
+func (t *PinTracker) Add(ctx context.Context, c cid.Cid, replicationMin, replicationMax int, name string) (PinIntent, error) {
+	if replicationMin < 1 {
+		replicationMin = 1
+	}
+	if replicationMax < replicationMin {
+		replicationMax = replicationMin
+	}
+
+	intent := PinIntent{
+		CID:            c.String(),
+		Name:           name,
+		ReplicationMin: replicationMin,
+		ReplicationMax: replicationMax,
+		Allocations:    allocate(c, clusterMembers(), replicationMax),
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	data, err := json.Marshal(intent)
+	if err != nil {
+		return intent, err
+	}
+	if err := t.intents.Put(ctx, intent.CID, data); err != nil {
+		return intent, err
+	}
+
+	// hooks only fire for remote updates
+	go t.track(context.Background(), intent)
+	return intent, nil
+}
+
+// Remove deletes the pin intent for c. Every node unpins it.
+func (t *PinTracker) Remove(ctx context.Context, c cid.Cid) error {
+	if err := t.intents.Delete(ctx, c.String()); err != nil {
+		return err
+	}
+	go t.untrack(context.Background(), c.String())
+	return nil
+}
+
+// Get returns the intent for c along with the status reported by each
+// peer, or datastore.ErrNotFound.
+func (t *PinTracker) Get(ctx context.Context, c cid.Cid) (PinInfo, error) {
+	data, err := t.intents.Get(ctx, c.String())
+	if err != nil {
+		return PinInfo{}, err
+	}
+
+	var intent PinIntent
+	if err := json.Unmarshal(data, &intent); err != nil {
+		return PinInfo{}, err
+	}
+
+	statuses, err := t.statuses(ctx)
+	if err != nil {
+		return PinInfo{}, err
+	}
+	return pinInfo(intent, statuses[intent.CID]), nil
+}
+
+// List returns every pin intent with the status reported by each peer.
+func (t *PinTracker) List(ctx context.Context) ([]PinInfo, error) {
+	values, err := t.intents.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := t.statuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]PinInfo, 0, len(values))
+	for _, data := range values {
+		var intent PinIntent
+		if err := json.Unmarshal(data, &intent); err != nil {
+			continue
+		}
+		infos = append(infos, pinInfo(intent, statuses[intent.CID]))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+	return infos, nil
+}
+
+// statuses groups the status entries, keyed "<cid>/<peer>", by CID.
+func (t *PinTracker) statuses(ctx context.Context) (map[string][]PinStatus, error) {
+	values, err := t.status.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string][]PinStatus)
+	for key, data := range values {
+		c, _, ok := strings.Cut(key, "/")
+		if !ok {
+			continue
+		}
+		var s PinStatus
+		if err := json.Unmarshal(data, &s); err != nil {
+			continue
+		}
+		statuses[c] = append(statuses[c], s)
+	}
+	for _, s := range statuses {
+		sort.Slice(s, func(i, j int) bool { return s[i].Peer < s[j].Peer })
+	}
+	return statuses, nil
+}
+
+func (t *PinTracker) setStatus(ctx context.Context, c, status string, pinErr error) {
+	self := ipfsNode.GetHost().ID().String()
+	s := PinStatus{Peer: self, Status: status, UpdatedAt: time.Now().UTC()}
+	if pinErr != nil {
+		s.Error = pinErr.Error()
+	}
+
+	data, _ := json.Marshal(s)
+	if err := t.status.Put(ctx, c+"/"+self, data); err != nil {
+		fmt.Printf("error while saving pin status of %s: %s\n", c, err.Error())
+	}
+}
+
+// track pins the CID of an intent if it is allocated to this node.
+func (t *PinTracker) track(ctx context.Context, intent PinIntent) {
+	self := ipfsNode.GetHost().ID().String()
+	allocated := false
+	for _, p := range intent.Allocations {
+		if p == self {
+			allocated = true
+		}
+	}
+	if !allocated {
+		return
+	}
+
+	c, err := cid.Decode(intent.CID)
+	if err != nil {
+		return
+	}
+	if pinned, _ := ipfsNode.IsPinned(ctx, c); pinned {
+		return
+	}
+
+	t.mu.Lock()
+	if t.pinning[intent.CID] {
+		t.mu.Unlock()
+		return
+	}
+	t.pinning[intent.CID] = true
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.pinning, intent.CID)
+		t.mu.Unlock()
+	}()
+
+	t.setStatus(ctx, intent.CID, PinStatusPinning, nil)
+
+	pctx, cancel := context.WithTimeout(ctx, pinTimeout)
+	defer cancel()
+	if err := ipfsNode.Pin(pctx, c); err != nil {
+		fmt.Printf("error while pinning %s: %s\n", intent.CID, err.Error())
+		t.setStatus(ctx, intent.CID, PinStatusFailed, err)
+		return
+	}
+
+	fmt.Printf("pinned %s\n", intent.CID)
+	t.setStatus(ctx, intent.CID, PinStatusPinned, nil)
+}
+
+// untrack unpins a CID whose intent was removed.
+func (t *PinTracker) untrack(ctx context.Context, key string) {
+	c, err := cid.Decode(key)
+	if err != nil {
+		return
+	}
+	if err := ipfsNode.Unpin(ctx, c); err != nil {
+		fmt.Printf("error while unpinning %s: %s\n", key, err.Error())
+	}
+
+	self := ipfsNode.GetHost().ID().String()
+	err = t.status.Delete(ctx, key+"/"+self)
+	if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		fmt.Printf("error while removing pin status of %s: %s\n", key, err.Error())
+	}
+}
+
+// reconcile periodically retries the intents allocated to this node that
+// are not pinned yet, e.g. after a failure or while the node was offline,
+// and allocates more peers to the intents that are under-replicated.
+func (t *PinTracker) reconcile(ctx context.Context) {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			infos, err := t.List(ctx)
+			if err != nil {
+				continue
+			}
+			members := clusterMembers()
+			for _, info := range infos {
+				if info.UnderReplicated {
+					if err := t.reallocate(ctx, info, members); err != nil {
+						fmt.Printf("error while reallocating %s: %s\n", info.CID, err.Error())
+					}
+				}
+				go t.track(ctx, info.PinIntent)
+			}
+		}
+	}
+}
+
+// reallocate replaces the allocations of an under-replicated pin that
+// failed or left the cluster with other members, up to ReplicationMax
+// peers. Allocated members that have not reported yet keep their turn.
+// Only the member that ranks first for the CID reallocates it, so that
+// nodes do not race to rewrite the intent.
+func (t *PinTracker) reallocate(ctx context.Context, info PinInfo, members []peer.ID) error {
+	c, err := cid.Decode(info.CID)
+	if err != nil {
+		return err
+	}
+	if first := allocate(c, members, 1); len(first) == 0 || first[0] != ipfsNode.GetHost().ID().String() {
+		return nil
+	}
+
+	failed := make(map[string]bool)
+	for _, s := range info.Peers {
+		if s.Status == PinStatusFailed {
+			failed[s.Peer] = true
+		}
+	}
+	isMember := make(map[string]bool)
+	for _, p := range members {
+		isMember[p.String()] = true
+	}
+
+	var allocations []string
+	for _, p := range info.Allocations {
+		if isMember[p] && !failed[p] {
+			allocations = append(allocations, p)
+		}
+	}
+	for _, p := range allocate(c, members, len(members)) {
+		if len(allocations) >= info.ReplicationMax {
+			break
+		}
+		if !failed[p] && !slices.Contains(allocations, p) {
+			allocations = append(allocations, p)
+		}
+	}
+	if slices.Equal(allocations, info.Allocations) {
+		return nil
+	}
+
+	intent := info.PinIntent
+	intent.Allocations = allocations
+	data, err := json.Marshal(intent)
+	if err != nil {
+		return err
+	}
+	if err := t.intents.Put(ctx, intent.CID, data); err != nil {
+		return err
+	}
+	fmt.Printf("reallocated %s to %s\n", intent.CID, strings.Join(allocations, ", "))
+	go t.track(ctx, intent)
+	return nil
+}
+
+// clusterMembers returns this node and the peers it knows to be part of
+// the cluster: the ones given with -peers and the ones subscribed to the
+// pins topic.
+func clusterMembers() []peer.ID {
+	members := map[peer.ID]bool{ipfsNode.GetHost().ID(): true}
+	if infos, err := peer.AddrInfosFromP2pAddrs(parseMultiaddrs(*clusterPeers)...); err == nil {
+		for _, info := range infos {
+			members[info.ID] = true
+		}
+	}
+	for _, p := range ipfsNode.TopicPeers(pinsTopic()) {
+		members[p] = true
+	}
+
+	ids := make([]peer.ID, 0, len(members))
+	for p := range members {
+		ids = append(ids, p)
+	}
+	return ids
+}
+
+// allocate picks up to n peers for c with rendezvous hashing, so that
+// allocations spread evenly and do not depend on the order peers are
+// known in.
+func allocate(c cid.Cid, peers []peer.ID, n int) []string {
+	score := func(p peer.ID) string {
+		h := sha256.Sum256(append(c.Bytes(), []byte(p)...))
+		return string(h[:])
+	}
+	sort.Slice(peers, func(i, j int) bool { return score(peers[i]) > score(peers[j]) })
+
+	if n > len(peers) {
+		n = len(peers)
+	}
+	allocations := make([]string, 0, n)
+	for _, p := range peers[:n] {
+		allocations = append(allocations, p.String())
+	}
+	return allocations
+}
+
+func getPinsHandler(w http.ResponseWriter, r *http.Request) {
+	infos, err := pinTracker.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error listing pins: %s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+func getPinHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := pinTracker.Get(r.Context(), c)
+	if errors.Is(err, datastore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Pin not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error reading pin: %s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// Pin a CID in the cluster. The replication factor defaults to the
+// -replication-min and -replication-max flags and can be set with the
+// query parameters of the same names.
+func addPinHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	replicationMin, replicationMax := *defaultReplicationMin, *defaultReplicationMax
+	if v := r.URL.Query().Get("replication-min"); v != "" {
+		if replicationMin, err = strconv.Atoi(v); err != nil {
+			invalidParameter(w, "replication-min", v)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("replication-max"); v != "" {
+		if replicationMax, err = strconv.Atoi(v); err != nil {
+			invalidParameter(w, "replication-max", v)
+			return
+		}
+	}
+	if replicationMin < 1 || replicationMax < replicationMin {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, CodeUnprocessable,
+			"replication-min must be at least 1 and at most replication-max",
+			map[string]int{"replication-min": replicationMin, "replication-max": replicationMax})
+		return
+	}
+
+	intent, err := pinTracker.Add(r.Context(), c, replicationMin, replicationMax, r.URL.Query().Get("name"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error pinning: %s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(intent)
+}
+
+func removePinHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := pathCid(w, r)
+	if !ok {
+		return
+	}
+
+	err := pinTracker.Remove(r.Context(), c)
+	if errors.Is(err, datastore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Pin not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error unpinning: %s", err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// PubSubMessage is a message received on a topic. Data is sent in base64,
+// since messages can be binary.
+type PubSubMessage struct {
+	Topic string `json:"topic"`
+	From  string `json:"from"`
+	Data  []byte `json:"data"`
+}
+
+func getTopicsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ipfsNode.Topics())
+}
+
+func getTopicPeersHandler(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("topic")
+
+	var peers []string
+	for _, p := range ipfsNode.TopicPeers(topic) {
+		peers = append(peers, p.String())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peers)
+}
+
+func publishHandler(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("topic")
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20)) // 1MB limit for a message
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not read message")
+		return
+	}
+
+	if err := ipfsNode.Publish(r.Context(), topic, data); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error publishing message: %s", err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Bridge a pubsub topic to a websocket: messages received on the topic are
+// sent to the client, and messages sent by the client are published.
+func topicSocketHandler(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("topic")
+
+	sub, err := ipfsNode.Subscribe(topic)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error subscribing to topic: %s", err.Error()))
+		return
+	}
+	defer sub.Cancel()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has replied
+	}
+	fmt.Printf("a websocket joined topic %s\n", topic)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			err = conn.WriteJSON(PubSubMessage{
+				Topic: topic,
+				From:  msg.GetFrom().String(),
+				Data:  msg.GetData(),
+			})
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			fmt.Printf("a websocket left topic %s\n", topic)
+			break
+		}
+		if err := ipfsNode.Publish(ctx, topic, data); err != nil {
+			fmt.Printf("error while publishing to %s: %s\n", topic, err.Error())
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// FileQuery selects, orders and pages catalog entries.
+type FileQuery struct {
+	Text    []string // terms that must all appear in the filename, type, description, tags or metadata
+	Name    string   // substring of the filename
+	Type    string   // MIME type, or a range such as image/*
+	Owner   string
+	Tags    []string // tags the file must all have
+	SizeMin int64    // -1 when unset
+	SizeMax int64    // -1 when unset
+	After   time.Time
+	Before  time.Time
+
+	AllVersions bool // include the older versions of files, not only the latest
+
+	Sort   string // uploadedAt, filename or size
+	Desc   bool
+	Limit  int
+	Cursor *fileCursor
+}
+
+// fileCursor marks the last entry of a page. The next page starts after
+// it, so pages stay consistent while files are added or removed.
+type fileCursor struct {
+	Sort       string    `json:"s"`
+	Desc       bool      `json:"d,omitempty"`
+	Filename   string    `json:"f,omitempty"`
+	Size       int64     `json:"z,omitempty"`
+	UploadedAt time.Time `json:"t"`
+	CID        string    `json:"c"`
+	Version    int       `json:"v,omitempty"`
+}
+
+func (c *fileCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*fileCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c fileCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// paramError reports an invalid query parameter.
+type paramError struct {
+	name, value string
+}
+
+func (e *paramError) Error() string {
+	return fmt.Sprintf("invalid %s %q", e.name, e.value)
+}
+
+// parseTime accepts RFC 3339 times and plain dates.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// parseFileQuery reads a FileQuery from the query parameters q, name,
+// type, owner, tag, size-min, size-max, uploaded-after, uploaded-before,
+// versions, sort, order, limit and cursor. Tags can be repeated or comma-separated.
+func parseFileQuery(values url.Values) (FileQuery, error) {
+	query := FileQuery{
+		Text:    strings.Fields(strings.ToLower(values.Get("q"))),
+		Name:    strings.ToLower(values.Get("name")),
+		Type:    strings.ToLower(values.Get("type")),
+		Owner:   values.Get("owner"),
+		Tags:    splitTags(strings.Join(values["tag"], ",")),
+		SizeMin: -1,
+		SizeMax: -1,
+		Sort:    "uploadedAt",
+		Limit:   defaultPageSize,
+	}
+
+	var err error
+	for name, size := range map[string]*int64{"size-min": &query.SizeMin, "size-max": &query.SizeMax} {
+		if v := values.Get(name); v != "" {
+			if *size, err = parseSize(v); err != nil {
+				return query, &paramError{name, v}
+			}
+		}
+	}
+	for name, t := range map[string]*time.Time{"uploaded-after": &query.After, "uploaded-before": &query.Before} {
+		if v := values.Get(name); v != "" {
+			if *t, err = parseTime(v); err != nil {
+				return query, &paramError{name, v}
+			}
+		}
+	}
+
+	switch v := values.Get("versions"); v {
+	case "", "latest":
+	case "all":
+		query.AllVersions = true
+	default:
+		return query, &paramError{"versions", v}
+	}
+
+	if v := values.Get("sort"); v != "" {
+		if v != "uploadedAt" && v != "filename" && v != "size" {
+			return query, &paramError{"sort", v}
+		}
+		query.Sort = v
+	}
+	switch v := values.Get("order"); v {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, &paramError{"order", v}
+	}
+
+	if v := values.Get("limit"); v != "" {
+		query.Limit, err = strconv.Atoi(v)
+		if err != nil || query.Limit < 1 || query.Limit > maxPageSize {
+			return query, &paramError{"limit", v}
+		}
+	}
+	if v := values.Get("cursor"); v != "" {
+		query.Cursor, err = decodeCursor(v)
+		// a cursor only makes sense in the order it was made for
+		if err != nil || query.Cursor.Sort != query.Sort || query.Cursor.Desc != query.Desc {
+			return query, &paramError{"cursor", v}
+		}
+	}
+	return query, nil
+}
+
+// Match reports whether an entry passes the filters of the query.
+func (q *FileQuery) Match(fileInfo FileInfo) bool {
+	filename := strings.ToLower(fileInfo.Filename)
+	fileType := strings.ToLower(fileInfo.Type)
+
+	if q.Name != "" && !strings.Contains(filename, q.Name) {
+		return false
+	}
+	if q.Type != "" {
+		if matched, _ := path.Match(q.Type, fileType); !matched {
+			return false
+		}
+	}
+	if q.Owner != "" && fileInfo.Owner != q.Owner {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !slices.Contains(fileInfo.Tags, tag) {
+			return false
+		}
+	}
+	if (q.SizeMin >= 0 && fileInfo.Size < q.SizeMin) || (q.SizeMax >= 0 && fileInfo.Size > q.SizeMax) {
+		return false
+	}
+	if (!q.After.IsZero() && fileInfo.UploadedAt.Before(q.After)) || (!q.Before.IsZero() && !fileInfo.UploadedAt.Before(q.Before)) {
+		return false
+	}
+
+	text := filename + " " + fileType + " " + strings.ToLower(fileInfo.Description) + " " + strings.Join(fileInfo.Tags, " ")
+	for _, value := range fileInfo.Metadata {
+		text += " " + strings.ToLower(value)
+	}
+	for _, term := range q.Text {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders entries by the sort key of the query, then by CID,
+// filename and version so that the order is total.
+func (q *FileQuery) compare(a, b *fileCursor) int {
+	var c int
+	switch q.Sort {
+	case "filename":
+		c = cmp.Or(cmp.Compare(strings.ToLower(a.Filename), strings.ToLower(b.Filename)), cmp.Compare(a.Filename, b.Filename))
+	case "size":
+		c = cmp.Compare(a.Size, b.Size)
+	default:
+		c = a.UploadedAt.Compare(b.UploadedAt)
+	}
+	c = cmp.Or(c, cmp.Compare(a.CID, b.CID), cmp.Compare(a.Filename, b.Filename), cmp.Compare(a.Version, b.Version))
+	if q.Desc {
+		return -c
+	}
+	return c
+}
+
+func (q *FileQuery) cursorOf(fileInfo FileInfo) *fileCursor {
+	c := &fileCursor{Sort: q.Sort, Desc: q.Desc, UploadedAt: fileInfo.UploadedAt, CID: fileInfo.CID,
+		Filename: fileInfo.Filename, Version: fileInfo.Version}
+	if q.Sort == "size" {
+		c.Size = fileInfo.Size
+	}
+	return c
+}
+
+// FilePage is a page of query results.
+type FilePage struct {
+	Files      []FileInfo `json:"files"`
+	Total      int        `json:"total"` // number of entries matching the query, on all pages
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// Run applies the query to the catalog entries.
+func (q *FileQuery) Run(fileInfos []FileInfo) FilePage {
+	latest := latestVersions(fileInfos)
+
+	matched := make([]FileInfo, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		if l := latest[fileInfo.Filename]; !q.AllVersions && (l.CID != fileInfo.CID || l.Version != fileInfo.Version) {
+			continue
+		}
+		if q.Match(fileInfo) {
+			matched = append(matched, fileInfo)
+		}
+	}
+	slices.SortFunc(matched, func(a, b FileInfo) int {
+		return q.compare(q.cursorOf(a), q.cursorOf(b))
+	})
+
+	page := FilePage{Files: matched, Total: len(matched)}
+	if q.Cursor != nil {
+		start, _ := slices.BinarySearchFunc(matched, q.Cursor, func(fileInfo FileInfo, c *fileCursor) int {
+			return q.compare(q.cursorOf(fileInfo), c)
+		})
+		// skip the entry of the cursor itself if it is still there
+		if start < len(matched) && q.compare(q.cursorOf(matched[start]), q.Cursor) == 0 {
+			start++
+		}
+		page.Files = matched[start:]
+	}
+	if len(page.Files) > q.Limit {
+		page.Files = page.Files[:q.Limit]
+		page.NextCursor = q.cursorOf(page.Files[len(page.Files)-1]).encode()
+	}
+	return page
+}
+
+// List the catalog entries matching the query parameters, one page at a
+// time.
+func getFileInfosHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := parseFileQuery(r.URL.Query())
+	var perr *paramError
+	if errors.As(err, &perr) {
+		invalidParameter(w, perr.name, perr.value)
+		return
+	}
+
+	fileInfos, err := catalog.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading the catalog")
+		return
+	}
+	writeJSON(w, query.Run(fileInfos))
+}
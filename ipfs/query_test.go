@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFileQueryRunPaging(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(filename string, version int, cid string, minutes int) FileInfo {
+		return FileInfo{Filename: filename, Version: version, CID: cid, UploadedAt: at.Add(time.Duration(minutes) * time.Minute)}
+	}
+	fileInfos := []FileInfo{
+		entry("a.txt", 1, "bafyx", 0),
+		entry("b.txt", 1, "bafyx", 0), // same content under another name
+		entry("a.txt", 2, "bafyy", 1),
+		entry("c.txt", 1, "bafyz", 2),
+		entry("c.txt", 2, "bafyw", 3),
+		entry("c.txt", 3, "bafyz", 4), // version 1 restored
+	}
+
+	tests := []struct {
+		name    string
+		all     bool
+		limit   int
+		removed string // entry removed after the first page
+		want    []string
+	}{
+		{"latest versions", false, 10, "", []string{"b.txt/1", "a.txt/2", "c.txt/3"}},
+		{"latest versions by page", false, 1, "", []string{"b.txt/1", "a.txt/2", "c.txt/3"}},
+		{"all versions", true, 10, "", []string{"a.txt/1", "b.txt/1", "a.txt/2", "c.txt/1", "c.txt/2", "c.txt/3"}},
+		{"all versions by page", true, 1, "", []string{"a.txt/1", "b.txt/1", "a.txt/2", "c.txt/1", "c.txt/2", "c.txt/3"}},
+		{"entry of the cursor removed", true, 1, "a.txt/1", []string{"a.txt/1", "b.txt/1", "a.txt/2", "c.txt/1", "c.txt/2", "c.txt/3"}},
+		{"entry of the cursor removed, pages of two", true, 2, "b.txt/1", []string{"a.txt/1", "b.txt/1", "a.txt/2", "c.txt/1", "c.txt/2", "c.txt/3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := FileQuery{AllVersions: tt.all, SizeMin: -1, SizeMax: -1, Sort: "uploadedAt", Limit: tt.limit}
+			entries := fileInfos
+			var got []string
+			for range len(fileInfos) + 1 {
+				page := q.Run(entries)
+				for _, fileInfo := range page.Files {
+					got = append(got, fmt.Sprintf("%s/%d", fileInfo.Filename, fileInfo.Version))
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				var err error
+				if q.Cursor, err = decodeCursor(page.NextCursor); err != nil {
+					t.Fatal(err)
+				}
+				entries = slices.DeleteFunc(slices.Clone(entries), func(fileInfo FileInfo) bool {
+					return fmt.Sprintf("%s/%d", fileInfo.Filename, fileInfo.Version) == tt.removed
+				})
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	ipfslite "ipfs-demo/ipfs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Quota limits the bytes stored and the files owned by a user or a group.
+// Zero means unlimited.
+type Quota struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+}
+
+// Quotas are read from a file, one per line as
+// "user|group <name> <bytes> <files>", e.g. "user alice 10GB 1000". The name
+// * sets the default of every user or group without a line of its own.
+// Blank lines and lines starting with # are ignored.
+type Quotas struct {
+	users  map[string]Quota
+	groups map[string]Quota
+}
+
+func LoadQuotas(path string) (*Quotas, error) {
+	q := &Quotas{users: make(map[string]Quota), groups: make(map[string]Quota)}
+	if path == "" {
+		return q, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("malformed quota line: %q", line)
+		}
+		bytes, err := parseSize(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("malformed quota line: %q: %w", line, err)
+		}
+		files, err := strconv.Atoi(fields[3])
+		if err != nil || files < 0 {
+			return nil, fmt.Errorf("malformed quota line: %q: invalid file count", line)
+		}
+
+		switch fields[0] {
+		case "user":
+			q.users[fields[1]] = Quota{Bytes: bytes, Files: files}
+		case "group":
+			q.groups[fields[1]] = Quota{Bytes: bytes, Files: files}
+		default:
+			return nil, fmt.Errorf("malformed quota line: %q: expected user or group", line)
+		}
+	}
+	return q, scanner.Err()
+}
+
+// User returns the quota of a user, and false when it has none.
+func (q *Quotas) User(name string) (Quota, bool) {
+	return lookupQuota(q.users, name)
+}
+
+// Group returns the quota of a group, and false when it has none.
+func (q *Quotas) Group(name string) (Quota, bool) {
+	return lookupQuota(q.groups, name)
+}
+
+func lookupQuota(quotas map[string]Quota, name string) (Quota, bool) {
+	if quota, ok := quotas[name]; ok {
+		return quota, true
+	}
+	quota, ok := quotas["*"]
+	return quota, ok
+}
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize parses a byte count such as 1048576, 512MiB or 10GB.
+func parseSize(s string) (int64, error) {
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, factor = strings.TrimSuffix(s, unit.suffix), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * factor, nil
+}
+
+// Usage is the storage held by the files of a user or a group. Bytes count
+// every block once however many of the files share it, and SharedBytes is
+// the part of Bytes also referenced by files of other owners.
+type Usage struct {
+	Bytes       int64  `json:"bytes"`
+	Files       int    `json:"files"`
+	SharedBytes int64  `json:"sharedBytes"`
+	Quota       *Quota `json:"quota,omitempty"`
+}
+
+// QuotaError is returned when an upload would exceed a quota.
+type QuotaError struct {
+	Scope string // user or group
+	Name  string
+	Usage Usage
+	Quota Quota
+}
+
+func (e *QuotaError) Error() string {
+	if e.Quota.Files > 0 && e.Usage.Files > e.Quota.Files {
+		return fmt.Sprintf("%s %s would own %d files, over its quota of %d", e.Scope, e.Name, e.Usage.Files, e.Quota.Files)
+	}
+	return fmt.Sprintf("%s %s would store %d bytes, over its quota of %d", e.Scope, e.Name, e.Usage.Bytes, e.Quota.Bytes)
+}
+
+// Accountant computes the usage of users and groups from the catalog and
+// enforces their quotas. Usage is measured on blocks rather than on file
+// sizes, so that content deduplicated between files is not charged twice to
+// the same owner.
+type Accountant struct {
+	quotas *Quotas
+
+	mu sync.Mutex // serializes quota checks with the uploads they admit
+
+	cacheMu sync.Mutex
+	blocks  map[string]map[string]uint64 // block sizes by file CID
+}
+
+func NewAccountant(quotas *Quotas) *Accountant {
+	return &Accountant{quotas: quotas, blocks: make(map[string]map[string]uint64)}
+}
+
+// fileBlocks returns the sizes of the blocks of a file, keyed by multihash.
+// Files whose DAG is not held locally are counted as a single block of
+// their size, since fetching them only to account for them would defeat
+// the purpose.
+func (a *Accountant) fileBlocks(ctx context.Context, fileInfo FileInfo) map[string]uint64 {
+	a.cacheMu.Lock()
+	sizes, ok := a.blocks[fileInfo.CID]
+	a.cacheMu.Unlock()
+	if ok {
+		return sizes
+	}
+
+	c, err := cid.Decode(fileInfo.CID)
+	if err == nil {
+		sizes, err = ipfsNode.LocalBlocks(ctx, c)
+	}
+	if err != nil {
+		return map[string]uint64{"file:" + fileInfo.CID: uint64(fileInfo.Size)}
+	}
+
+	// files are immutable, so their blocks can be cached for good
+	a.cacheMu.Lock()
+	a.blocks[fileInfo.CID] = sizes
+	a.cacheMu.Unlock()
+	return sizes
+}
+
+// usage sums the blocks of the files matched by owns. It also returns the
+// set of those blocks.
+func (a *Accountant) usage(ctx context.Context, fileInfos []FileInfo, owns func(FileInfo) bool) (Usage, map[string]uint64) {
+	var usage Usage
+	held := make(map[string]uint64)
+	others := make(map[string]bool)
+	for _, fileInfo := range fileInfos {
+		blocks := a.fileBlocks(ctx, fileInfo)
+		if !owns(fileInfo) {
+			for k := range blocks {
+				others[k] = true
+			}
+			continue
+		}
+		usage.Files++
+		for k, size := range blocks {
+			held[k] = size
+		}
+	}
+
+	for k, size := range held {
+		usage.Bytes += int64(size)
+		if others[k] {
+			usage.SharedBytes += int64(size)
+		}
+	}
+	return usage, held
+}
+
+func ownedBy(user string) func(FileInfo) bool {
+	return func(fileInfo FileInfo) bool { return fileInfo.Owner == user }
+}
+
+func ownedByGroup(group string) func(FileInfo) bool {
+	return func(fileInfo FileInfo) bool {
+		for _, g := range fileInfo.Groups {
+			if g == group {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Admit checks that storing a staged file would keep the principal and its
+// groups within their quotas, and if so runs commit. Checks and commits are
+// serialized so that concurrent uploads cannot overrun a quota together.
+// Every upload counts as a file, but only the blocks an owner does not hold
+// already are charged to it.
+func (a *Accountant) Admit(ctx context.Context, principal *Principal, staged *ipfslite.StagedFile, commit func() error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fileInfos, err := catalog.List(ctx)
+	if err != nil {
+		return err
+	}
+	blocks := staged.Blocks()
+
+	check := func(scope, name string, quota Quota, owns func(FileInfo) bool) error {
+		usage, held := a.usage(ctx, fileInfos, owns)
+		usage.Files++
+		for k, size := range blocks {
+			if _, ok := held[k]; !ok {
+				usage.Bytes += int64(size)
+			}
+		}
+		if (quota.Bytes > 0 && usage.Bytes > quota.Bytes) || (quota.Files > 0 && usage.Files > quota.Files) {
+			return &QuotaError{Scope: scope, Name: name, Usage: usage, Quota: quota}
+		}
+		return nil
+	}
+
+	if quota, ok := a.quotas.User(principal.User); ok {
+		if err := check("user", principal.User, quota, ownedBy(principal.User)); err != nil {
+			return err
+		}
+	}
+	for _, group := range principal.Groups {
+		if quota, ok := a.quotas.Group(group); ok {
+			if err := check("group", group, quota, ownedByGroup(group)); err != nil {
+				return err
+			}
+		}
+	}
+	return commit()
+}
+
+// Room returns the bytes that the principal and each of its groups can
+// still store within their byte quotas, by "user <name>" or
+// "group <name>". Scopes without a byte quota are left out. Blocks stored
+// already are not deducted, so this is the room for new content.
+func (a *Accountant) Room(ctx context.Context, principal *Principal) (map[string]int64, error) {
+	fileInfos, err := catalog.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	room := make(map[string]int64)
+	add := func(scope, name string, quota Quota, owns func(FileInfo) bool) {
+		if quota.Bytes == 0 {
+			return
+		}
+		usage, _ := a.usage(ctx, fileInfos, owns)
+		room[scope+" "+name] = max(quota.Bytes-usage.Bytes, 0)
+	}
+	if quota, ok := a.quotas.User(principal.User); ok {
+		add("user", principal.User, quota, ownedBy(principal.User))
+	}
+	for _, group := range principal.Groups {
+		if quota, ok := a.quotas.Group(group); ok {
+			add("group", group, quota, ownedByGroup(group))
+		}
+	}
+	return room, nil
+}
+
+// Collect deletes the local blocks of a file removed from the catalog that
+// no other file uses. It excludes uploads while it runs, so that none can
+// start relying on the blocks being deleted.
+func (a *Accountant) Collect(ctx context.Context, c cid.Cid) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fileInfos, err := catalog.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[string]bool)
+	for _, fileInfo := range fileInfos {
+		for k := range a.fileBlocks(ctx, fileInfo) {
+			used[k] = true
+		}
+	}
+
+	a.cacheMu.Lock()
+	delete(a.blocks, c.String())
+	a.cacheMu.Unlock()
+
+	return ipfsNode.RemoveUnique(ctx, c, func(mh string) bool { return used[mh] })
+}
+
+// UserUsage returns the usage of a user along with its quota.
+func (a *Accountant) UserUsage(ctx context.Context, user string) (Usage, error) {
+	fileInfos, err := catalog.List(ctx)
+	if err != nil {
+		return Usage{}, err
+	}
+	usage, _ := a.usage(ctx, fileInfos, ownedBy(user))
+	if quota, ok := a.quotas.User(user); ok {
+		usage.Quota = &quota
+	}
+	return usage, nil
+}
+
+// GroupUsage returns the usage of a group along with its quota.
+func (a *Accountant) GroupUsage(ctx context.Context, group string) (Usage, error) {
+	fileInfos, err := catalog.List(ctx)
+	if err != nil {
+		return Usage{}, err
+	}
+	usage, _ := a.usage(ctx, fileInfos, ownedByGroup(group))
+	if quota, ok := a.quotas.Group(group); ok {
+		usage.Quota = &quota
+	}
+	return usage, nil
+}
+
+type UsageReport struct {
+	User   string           `json:"user"`
+	Usage  Usage            `json:"usage"`
+	Groups map[string]Usage `json:"groups,omitempty"`
+}
+
+// Report the usage and quotas of the requesting user and of their groups.
+func getUsageHandler(w http.ResponseWriter, r *http.Request) {
+	principal := principalFrom(r.Context())
+	report := UsageReport{User: principal.User}
+
+	var err error
+	report.Usage, err = accountant.UserUsage(r.Context(), principal.User)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading the catalog")
+		return
+	}
+	for _, group := range principal.Groups {
+		if report.Groups == nil {
+			report.Groups = make(map[string]Usage)
+		}
+		report.Groups[group], err = accountant.GroupUsage(r.Context(), group)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading the catalog")
+			return
+		}
+	}
+	writeJSON(w, report)
+}
+
+func getUserUsageHandler(w http.ResponseWriter, r *http.Request) {
+	usage, err := accountant.UserUsage(r.Context(), r.PathValue("user"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading the catalog")
+		return
+	}
+	writeJSON(w, usage)
+}
+
+func getGroupUsageHandler(w http.ResponseWriter, r *http.Request) {
+	usage, err := accountant.GroupUsage(r.Context(), r.PathValue("group"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading the catalog")
+		return
+	}
+	writeJSON(w, usage)
+}
+
+// quotaError writes the error of Admit.
+func quotaError(w http.ResponseWriter, err error) {
+	var qerr *QuotaError
+	if errors.As(err, &qerr) {
+		writeErrorDetails(w, http.StatusRequestEntityTooLarge, CodeQuotaExceeded, fmt.Sprintf("Quota exceeded: %s", qerr.Error()),
+			map[string]any{"scope": qerr.Scope, "name": qerr.Name, "usage": qerr.Usage, "quota": qerr.Quota})
+		return
+	}
+	if errors.Is(err, errVersionExists) {
+		writeError(w, http.StatusConflict, CodeConflict, fmt.Sprintf("Upload conflicts with another one, try again: %s", err.Error()))
+		return
+	}
+	writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error saving file to IPFS: %s", err.Error()))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newTestCatalog starts a test node with an empty catalog.
+func newTestCatalog(t *testing.T) context.Context {
+	t.Helper()
+	ctx := newTestNode(t)
+	store, err := ipfsNode.NewCRDT(ctx, "catalog", "test/catalog", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog = NewCatalog(store)
+
+	// nothing sends the events of the catalog to websockets in tests
+	go func() {
+		for {
+			select {
+			case <-broadcastChan:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ctx
+}
+
+// addTestFile stores content on the test node and records it in the
+// catalog.
+func addTestFile(t *testing.T, ctx context.Context, fileInfo FileInfo, content string) FileInfo {
+	t.Helper()
+	staged, err := ipfsNode.StageFile(ctx, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer staged.Discard()
+	if err := ipfsNode.Commit(ctx, staged); err != nil {
+		t.Fatal(err)
+	}
+	fileInfo.CID = staged.Root.Cid().String()
+	fileInfo.Size = int64(len(content))
+	if fileInfo.Version == 0 {
+		fileInfo.Version = 1
+	}
+	if _, err := catalog.Add(ctx, fileInfo); err != nil {
+		t.Fatal(err)
+	}
+	return fileInfo
+}
+
+func stagedSize(t *testing.T, ctx context.Context, content string) int64 {
+	t.Helper()
+	staged, err := ipfsNode.StageFile(ctx, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer staged.Discard()
+	var size int64
+	for _, n := range staged.Blocks() {
+		size += int64(n)
+	}
+	return size
+}
+
+func TestAdmitDedup(t *testing.T) {
+	ctx := newTestCatalog(t)
+	const mine, theirs, fresh = "content of alice", "content of bob, in the team", "content nobody has yet"
+	addTestFile(t, ctx, FileInfo{Filename: "a.txt", Owner: "alice", Groups: []string{"team"}}, mine)
+	addTestFile(t, ctx, FileInfo{Filename: "b.txt", Owner: "bob", Groups: []string{"team"}}, theirs)
+	sizeMine, sizeTheirs, sizeFresh := stagedSize(t, ctx, mine), stagedSize(t, ctx, theirs), stagedSize(t, ctx, fresh)
+
+	tests := []struct {
+		name    string
+		user    *Quota
+		group   *Quota
+		content string
+		admit   bool
+	}{
+		{"new content within quota", &Quota{Bytes: sizeMine + sizeFresh, Files: 2}, nil, fresh, true},
+		{"new content over bytes", &Quota{Bytes: sizeMine + sizeFresh - 1}, nil, fresh, false},
+		{"own content not charged again", &Quota{Bytes: sizeMine, Files: 2}, nil, mine, true},
+		{"own content still counts as a file", &Quota{Files: 1}, nil, mine, false},
+		{"content of another user is charged", &Quota{Bytes: sizeMine + sizeTheirs - 1}, nil, theirs, false},
+		{"content of another user within quota", &Quota{Bytes: sizeMine + sizeTheirs, Files: 2}, nil, theirs, true},
+		{"content of another user at file limit", &Quota{Files: 1}, nil, theirs, false},
+		{"content of the group not charged again", nil, &Quota{Bytes: sizeMine + sizeTheirs, Files: 3}, theirs, true},
+		{"content of the group still counts as a file", nil, &Quota{Files: 2}, theirs, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quotas := &Quotas{users: make(map[string]Quota), groups: make(map[string]Quota)}
+			if tt.user != nil {
+				quotas.users["alice"] = *tt.user
+			}
+			if tt.group != nil {
+				quotas.groups["team"] = *tt.group
+			}
+			a := NewAccountant(quotas)
+
+			staged, err := ipfsNode.StageFile(ctx, strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer staged.Discard()
+
+			committed := false
+			principal := &Principal{User: "alice", Roles: []string{RoleUpload}, Groups: []string{"team"}}
+			err = a.Admit(ctx, principal, staged, func() error {
+				committed = true
+				return nil
+			})
+			var qerr *QuotaError
+			if tt.admit && err != nil {
+				t.Fatalf("refused: %s", err)
+			}
+			if !tt.admit && !errors.As(err, &qerr) {
+				t.Fatalf("error = %v, want a quota error", err)
+			}
+			if committed != tt.admit {
+				t.Errorf("committed = %v, want %v", committed, tt.admit)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit configures the limits of a class of routes. Each client, known
+// by its IP address and by its bearer token when it sends one, may make
+// Rate requests per second with bursts of Burst, and at most Concurrent
+// requests of the class run at once on the node. Zero disables a limit.
+type RateLimit struct {
+	Rate       float64
+	Burst      int
+	Concurrent int
+}
+
+// The limits of the route classes, which -rate-limits can override.
+var rateLimits = map[string]RateLimit{
+	"upload":   {Rate: 1, Burst: 10, Concurrent: 4},
+	"download": {Rate: 10, Burst: 50, Concurrent: 32},
+	"api":      {Rate: 20, Burst: 100},
+}
+
+// parseRateLimits overrides rateLimits with a comma-separated list of
+// "<class>=<rate>:<burst>:<concurrent>", e.g. "upload=0.5:5:2".
+func parseRateLimits(s string) error {
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		class, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("malformed rate limit %q", entry)
+		}
+		if _, ok := rateLimits[class]; !ok {
+			return fmt.Errorf("unknown route class %q", class)
+		}
+
+		parts := strings.Split(spec, ":")
+		if len(parts) != 3 {
+			return fmt.Errorf("malformed rate limit %q", entry)
+		}
+		r, err1 := strconv.ParseFloat(parts[0], 64)
+		burst, err2 := strconv.Atoi(parts[1])
+		concurrent, err3 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || err3 != nil || r < 0 || burst < 0 || concurrent < 0 {
+			return fmt.Errorf("malformed rate limit %q", entry)
+		}
+		rateLimits[class] = RateLimit{Rate: r, Burst: burst, Concurrent: concurrent}
+	}
+	return nil
+}
+
+// idleLimiterTTL is how long the bucket of a client is kept after its last
+// request. A full bucket is the same as a new one, so nothing is lost.
+const idleLimiterTTL = 10 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter enforces the RateLimit of a class of routes.
+type RateLimiter struct {
+	class  string
+	limit  RateLimit
+	active chan struct{} // semaphore of the running requests
+
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+func NewRateLimiter(class string, limit RateLimit) *RateLimiter {
+	l := &RateLimiter{
+		class:   class,
+		limit:   limit,
+		clients: make(map[string]*clientLimiter),
+	}
+	if limit.Concurrent > 0 {
+		l.active = make(chan struct{}, limit.Concurrent)
+	}
+	go l.evictIdle()
+	return l
+}
+
+func (l *RateLimiter) evictIdle() {
+	for range time.Tick(time.Minute) {
+		l.mu.Lock()
+		for key, c := range l.clients {
+			if time.Since(c.lastSeen) > idleLimiterTTL {
+				delete(l.clients, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// reserve takes a token from the bucket of every key. When one is empty,
+// nothing is taken and the time until it refills is returned.
+func (l *RateLimiter) reserve(keys []string) time.Duration {
+	if l.limit.Rate <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var reservations []*rate.Reservation
+	var wait time.Duration
+	for _, key := range keys {
+		c, ok := l.clients[key]
+		if !ok {
+			c = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(l.limit.Rate), max(l.limit.Burst, 1))}
+			l.clients[key] = c
+		}
+		c.lastSeen = now
+
+		res := c.limiter.ReserveN(now, 1)
+		reservations = append(reservations, res)
+		wait = max(wait, res.DelayFrom(now))
+	}
+
+	if wait > 0 {
+		for _, res := range reservations {
+			res.CancelAt(now)
+		}
+	}
+	return wait
+}
+
+// clientKeys identifies the client of a request by its IP address and, if
+// it sends one, by a hash of its bearer token, so that a token is limited
+// wherever it is used from.
+func clientKeys(r *http.Request) []string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	keys := []string{"ip:" + host}
+	if token := bearerToken(r); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		keys = append(keys, fmt.Sprintf("token:%x", sum[:8]))
+	}
+	return keys
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, CodeRateLimited, msg)
+}
+
+// Wrap limits the requests made to next.
+func (l *RateLimiter) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wait := l.reserve(clientKeys(r)); wait > 0 {
+			fmt.Printf("rate limited %s request from %s\n", l.class, r.RemoteAddr)
+			tooManyRequests(w, wait, "Too many requests")
+			return
+		}
+
+		if l.active != nil {
+			select {
+			case l.active <- struct{}{}:
+				defer func() { <-l.active }()
+			default:
+				tooManyRequests(w, time.Second, fmt.Sprintf("Too many concurrent %s requests", l.class))
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// rateLimited returns a function wrapping handlers with the limiter of a
+// route class, creating the limiters on first use.
+func rateLimited() func(class string, next http.HandlerFunc) http.HandlerFunc {
+	limiters := make(map[string]*RateLimiter)
+	return func(class string, next http.HandlerFunc) http.HandlerFunc {
+		l, ok := limiters[class]
+		if !ok {
+			l = NewRateLimiter(class, rateLimits[class])
+			limiters[class] = l
+		}
+		return l.Wrap(next)
+	}
+}
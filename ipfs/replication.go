@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	ipfslite "ipfs-demo/ipfs"
+	"net/http"
+
+	"github.com/ipfs/go-datastore"
+)
+
+// Nodes started with the same -cluster name share their catalog on this
+// topic.
+func catalogTopic() string {
+	return "ipfs-demo/catalog/" + *clusterName
+}
+
+// Create the catalog shared with the other nodes of the cluster. Entries
+// added, changed or removed by other nodes are forwarded to the websocket
+// clients just like local changes.
+func setUpCatalog(ctx context.Context) (*Catalog, error) {
+	// The hooks look up the catalog being built rather than the global,
+	// which is only set once the node is up. They run on the goroutines of
+	// the store, which may start before its creation returns.
+	catalog := &Catalog{}
+	ready := make(chan struct{})
+	store, err := ipfsNode.NewCRDT(ctx, "catalog", catalogTopic(), &ipfslite.CRDTOptions{
+		PutHook: func(key string, value []byte) {
+			var fileInfo FileInfo
+			if err := json.Unmarshal(value, &fileInfo); err != nil {
+				fmt.Printf("dropping malformed catalog entry %s: %s\n", key, err.Error())
+				return
+			}
+			broadcastChan <- fileInfo
+		},
+		DeleteHook: func(key string) {
+			<-ready
+			// the content is only gone with its last entry
+			c := keyCID(key)
+			if _, err := catalog.Get(ctx, c); errors.Is(err, datastore.ErrNotFound) {
+				broadcastChan <- FileEvent{Event: FileDeleted, CID: c}
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	catalog.store = store
+	close(ready)
+	return catalog, nil
+}
+
+func getCatalogStatusHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := catalog.store.Status(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error reading catalog status: %s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// Fetch and merge catalog updates that were missed, e.g. while this node
+// was offline.
+func repairCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	if err := catalog.store.Repair(r.Context()); err != nil {
+		writeError(w, http.StatusGatewayTimeout, CodeTimeout, fmt.Sprintf("Catalog repair incomplete: %s", err.Error()))
+		return
+	}
+	getCatalogStatusHandler(w, r)
+}
@@ -0,0 +1,1119 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	ipfslite "ipfs-demo/ipfs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+)
+
+const (
+	s3Namespace  = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+	maxS3Keys      = 1000
+	maxS3KeyLength = 1024
+	maxS3XMLBody   = 1 << 20
+
+	// cidHeader gives the CID of the content of an object in responses.
+	cidHeader = "X-Ipfs-Cid"
+)
+
+// Bucket is a namespace of S3 objects.
+type Bucket struct {
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// S3Object maps the key of an object in a bucket to its content. Objects
+// are recorded in the catalog only: the content is added like an upload,
+// as a version of the filename s3://<bucket>/<key>, and the object is the
+// latest version of that filename.
+type S3Object struct {
+	Bucket       string            `json:"bucket"`
+	Key          string            `json:"key"`
+	CID          string            `json:"cid"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag"` // quoted, as in responses
+	ContentType  string            `json:"contentType,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"` // x-amz-meta-* headers
+	Encrypted    bool              `json:"encrypted,omitempty"`
+	Owner        string            `json:"owner,omitempty"`
+	LastModified time.Time         `json:"lastModified"`
+}
+
+// s3Store holds the buckets and objects served by the S3 API, nil when it
+// is not enabled.
+var s3Store *S3Store
+
+// S3Store keeps the buckets of the cluster in a CRDT store, so that any
+// node can serve them, and their objects in the catalog. Multipart uploads
+// in progress are kept by the node they were started on, in temporary
+// files.
+type S3Store struct {
+	buckets *ipfslite.CRDT
+	dir     string // parts of multipart uploads and bodies being received
+
+	mu      sync.Mutex
+	uploads map[string]*multipartUpload
+	pending map[string]int64 // bytes received but not imported, by quota scope
+}
+
+func s3BucketsTopic() string {
+	return "ipfs-demo/s3buckets/" + *clusterName
+}
+
+func setUpS3(ctx context.Context) (*S3Store, error) {
+	dir, err := os.MkdirTemp("", "ipfs-demo-s3-")
+	if err != nil {
+		return nil, err
+	}
+	s := &S3Store{dir: dir, uploads: make(map[string]*multipartUpload), pending: make(map[string]int64)}
+	s.buckets, err = ipfsNode.NewCRDT(ctx, "s3buckets", s3BucketsTopic(), nil)
+	if err != nil {
+		return nil, err
+	}
+	go s.expireUploads(ctx)
+	return s, nil
+}
+
+// s3FilenamePrefix starts the catalog filenames of S3 objects, which
+// uploads cannot use.
+const s3FilenamePrefix = "s3://"
+
+// objectFilename returns the catalog filename of an object.
+func objectFilename(bucket, key string) string {
+	return s3FilenamePrefix + bucket + "/" + key
+}
+
+// objectOf returns the object of a catalog entry of an object filename.
+func objectOf(fileInfo FileInfo) S3Object {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(fileInfo.Filename, s3FilenamePrefix), "/")
+	return S3Object{
+		Bucket:       bucket,
+		Key:          key,
+		CID:          fileInfo.CID,
+		Size:         fileInfo.Size,
+		ETag:         fileInfo.ETag,
+		ContentType:  fileInfo.Type,
+		Metadata:     fileInfo.Metadata,
+		Encrypted:    fileInfo.Encrypted,
+		Owner:        fileInfo.Owner,
+		LastModified: fileInfo.UploadedAt,
+	}
+}
+
+// Bucket returns a bucket, or datastore.ErrNotFound.
+func (s *S3Store) Bucket(ctx context.Context, name string) (Bucket, error) {
+	var bucket Bucket
+	data, err := s.buckets.Get(ctx, name)
+	if err != nil {
+		return bucket, err
+	}
+	err = json.Unmarshal(data, &bucket)
+	return bucket, err
+}
+
+// Buckets returns all buckets, by name.
+func (s *S3Store) Buckets(ctx context.Context) ([]Bucket, error) {
+	values, err := s.buckets.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]Bucket, 0, len(values))
+	for _, data := range values {
+		var bucket Bucket
+		if err := json.Unmarshal(data, &bucket); err != nil {
+			continue
+		}
+		buckets = append(buckets, bucket)
+	}
+	slices.SortFunc(buckets, func(a, b Bucket) int { return strings.Compare(a.Name, b.Name) })
+	return buckets, nil
+}
+
+// Object returns an object, or datastore.ErrNotFound.
+func (s *S3Store) Object(ctx context.Context, bucket, key string) (S3Object, error) {
+	versions, err := catalog.Versions(ctx, objectFilename(bucket, key))
+	if err != nil {
+		return S3Object{}, err
+	}
+	if len(versions) == 0 {
+		return S3Object{}, datastore.ErrNotFound
+	}
+	return objectOf(versions[len(versions)-1]), nil
+}
+
+// Objects returns the objects of a bucket, by key.
+func (s *S3Store) Objects(ctx context.Context, bucket string) ([]S3Object, error) {
+	fileInfos, err := catalog.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := objectFilename(bucket, "")
+	var objects []S3Object
+	for filename, fileInfo := range latestVersions(fileInfos) {
+		if strings.HasPrefix(filename, prefix) {
+			objects = append(objects, objectOf(fileInfo))
+		}
+	}
+	slices.SortFunc(objects, func(a, b S3Object) int { return strings.Compare(a.Key, b.Key) })
+	return objects, nil
+}
+
+// put adds content as the object described by obj, as the next version of
+// its key. The content is imported like an upload of principal.
+func (s *S3Store) put(ctx context.Context, principal *Principal, obj S3Object, content io.Reader) (S3Object, error) {
+	fileInfo, err := importFile(ctx, principal, FileInfo{
+		Filename:  objectFilename(obj.Bucket, obj.Key),
+		Type:      obj.ContentType,
+		Metadata:  obj.Metadata,
+		Encrypted: *encryptUploads,
+		ETag:      obj.ETag,
+	}, content)
+	if err != nil {
+		return obj, err
+	}
+	return objectOf(fileInfo), nil
+}
+
+// S3Error is the body of the error responses of the S3 API.
+type S3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Status   int      `xml:"-"`
+	Code     string
+	Message  string
+	Resource string `xml:",omitempty"`
+}
+
+func (e *S3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newS3Error(status int, code, message string) *S3Error {
+	return &S3Error{Status: status, Code: code, Message: message}
+}
+
+func s3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeS3Error(w, r, newS3Error(status, code, message))
+}
+
+// writeS3Error writes err as an S3 error. Errors other than S3Errors are
+// quota or internal errors.
+func writeS3Error(w http.ResponseWriter, r *http.Request, err error) {
+	var serr *S3Error
+	var qerr *QuotaError
+	switch {
+	case errors.As(err, &serr):
+	case errors.As(err, &qerr):
+		serr = newS3Error(http.StatusRequestEntityTooLarge, "QuotaExceeded", fmt.Sprintf("Quota exceeded: %s", qerr.Error()))
+	case errors.Is(err, errVersionExists):
+		serr = newS3Error(http.StatusConflict, "OperationAborted", "A conflicting upload of the object is in progress, try again")
+	case errors.Is(err, context.DeadlineExceeded):
+		serr = newS3Error(http.StatusGatewayTimeout, "RequestTimeout", "Timed out retrieving the content from the network")
+	default:
+		serr = newS3Error(http.StatusInternalServerError, "InternalError", err.Error())
+	}
+	resp := *serr
+	resp.Resource = r.URL.Path
+	// responses to HEAD have no body
+	if r.Method == http.MethodHead {
+		w.WriteHeader(resp.Status)
+		return
+	}
+	writeXML(w, resp.Status, resp)
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// s3Auth is requireRole for the S3 API, with S3 errors.
+func s3Auth(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authenticate(r)
+		switch {
+		case errors.Is(err, errUnknownAccessKey):
+			s3Error(w, r, http.StatusForbidden, "InvalidAccessKeyId", "The access key does not exist")
+		case errors.Is(err, errSignatureMismatch):
+			s3Error(w, r, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match")
+		case errors.Is(err, errRequestExpired):
+			s3Error(w, r, http.StatusForbidden, "AccessDenied", "Request has expired or its time is too far from the time of the server")
+		case err != nil:
+			s3Error(w, r, http.StatusForbidden, "AccessDenied", "Missing or invalid credentials")
+		case !principal.HasRole(role):
+			s3Error(w, r, http.StatusForbidden, "AccessDenied", fmt.Sprintf("Role %s required", role))
+		default:
+			next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+		}
+	}
+}
+
+// s3Subresources are the query parameters that select another operation
+// than the plain one on a bucket or object.
+var s3Subresources = []string{
+	"accelerate", "acl", "analytics", "attributes", "cors", "delete", "encryption",
+	"intelligent-tiering", "inventory", "legal-hold", "lifecycle", "location", "logging",
+	"metrics", "notification", "object-lock", "ownershipControls", "partNumber", "policy",
+	"publicAccessBlock", "replication", "requestPayment", "restore", "retention", "select",
+	"tagging", "torrent", "uploadId", "uploads", "versionId", "versioning", "versions", "website",
+}
+
+// unsupported writes a NotImplemented error and returns true when the
+// request selects a subresource other than the handled ones.
+func unsupported(w http.ResponseWriter, r *http.Request, handled ...string) bool {
+	query := r.URL.Query()
+	for _, sub := range s3Subresources {
+		if query.Has(sub) && !slices.Contains(handled, sub) {
+			s3Error(w, r, http.StatusNotImplemented, "NotImplemented", fmt.Sprintf("?%s is not supported", sub))
+			return true
+		}
+	}
+	return false
+}
+
+// validBucketName checks the S3 naming rules of buckets: 3 to 63
+// lowercase letters, digits, dots and hyphens, starting and ending with a
+// letter or digit.
+func validBucketName(name string) bool {
+	if len(name) < 3 || len(name) > 63 {
+		return false
+	}
+	for i, c := range name {
+		alnum := 'a' <= c && c <= 'z' || '0' <= c && c <= '9'
+		if !alnum && (c != '.' && c != '-' || i == 0 || i == len(name)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathBucket returns the bucket named in the request path. It writes the
+// error and returns false when it does not exist.
+func pathBucket(w http.ResponseWriter, r *http.Request) (Bucket, bool) {
+	name := r.PathValue("bucket")
+	bucket, err := s3Store.Bucket(r.Context(), name)
+	if errors.Is(err, datastore.ErrNotFound) {
+		s3Error(w, r, http.StatusNotFound, "NoSuchBucket", fmt.Sprintf("Bucket %s does not exist", name))
+		return bucket, false
+	}
+	if err != nil {
+		writeS3Error(w, r, err)
+		return bucket, false
+	}
+	return bucket, true
+}
+
+// pathObjectKey returns the object key of the request path, checking that
+// its bucket exists.
+func pathObjectKey(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	bucket, ok := pathBucket(w, r)
+	if !ok {
+		return "", "", false
+	}
+	key := r.PathValue("key")
+	if len(key) > maxS3KeyLength {
+		s3Error(w, r, http.StatusBadRequest, "KeyTooLongError", fmt.Sprintf("Keys are at most %d bytes", maxS3KeyLength))
+		return "", "", false
+	}
+	return bucket.Name, key, true
+}
+
+// canChange reports whether the principal of the request may delete what
+// owner created.
+func canChange(r *http.Request, owner string) bool {
+	principal := principalFrom(r.Context())
+	return owner == principal.User || principal.HasRole(RoleAdmin)
+}
+
+// writeAllowed checks that the principal of the request owns the bucket or
+// the object at key, or is an admin, before the object is written or
+// deleted. Writing an object makes the writer its owner. It writes the
+// error and returns false otherwise.
+func writeAllowed(w http.ResponseWriter, r *http.Request, bucket, key string) bool {
+	b, err := s3Store.Bucket(r.Context(), bucket)
+	if err != nil {
+		writeS3Error(w, r, err)
+		return false
+	}
+	if canChange(r, b.Owner) {
+		return true
+	}
+	obj, err := s3Store.Object(r.Context(), bucket, key)
+	if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		writeS3Error(w, r, err)
+		return false
+	}
+	if err == nil && canChange(r, obj.Owner) {
+		return true
+	}
+	s3Error(w, r, http.StatusForbidden, "AccessDenied", "Only the owner of the bucket or of the object, or an admin, can change it")
+	return false
+}
+
+// s3Handler serves the S3 API with path-style addressing, as in
+// http://localhost:9000/<bucket>/<key>. limit applies the rate limit of
+// each kind of request.
+func s3Handler(limit func(class string, next http.HandlerFunc) http.HandlerFunc) http.Handler {
+	// /<bucket>/ addresses the bucket, like /<bucket>
+	orBucket := func(bucketHandler, objectHandler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.PathValue("key") == "" {
+				bucketHandler(w, r)
+				return
+			}
+			objectHandler(w, r)
+		}
+	}
+	notImplemented := func(w http.ResponseWriter, r *http.Request) {
+		s3Error(w, r, http.StatusNotImplemented, "NotImplemented", "Operations on buckets by POST are not supported")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", limit("api", s3Auth(RoleRead, listBucketsHandler)))
+	mux.HandleFunc("PUT /{bucket}", limit("api", s3Auth(RoleUpload, createBucketHandler)))
+	mux.HandleFunc("HEAD /{bucket}", limit("api", s3Auth(RoleRead, headBucketHandler)))
+	mux.HandleFunc("GET /{bucket}", limit("api", s3Auth(RoleRead, listObjectsHandler)))
+	mux.HandleFunc("DELETE /{bucket}", limit("api", s3Auth(RoleUpload, deleteBucketHandler)))
+	mux.HandleFunc("POST /{bucket}", limit("api", s3Auth(RoleUpload, notImplemented)))
+	mux.HandleFunc("PUT /{bucket}/{key...}", limit("upload", s3Auth(RoleUpload, orBucket(createBucketHandler, putObjectHandler))))
+	mux.HandleFunc("HEAD /{bucket}/{key...}", limit("api", s3Auth(RoleRead, orBucket(headBucketHandler, headObjectHandler))))
+	mux.HandleFunc("GET /{bucket}/{key...}", limit("download", s3Auth(RoleRead, orBucket(listObjectsHandler, getObjectHandler))))
+	mux.HandleFunc("DELETE /{bucket}/{key...}", limit("api", s3Auth(RoleUpload, orBucket(deleteBucketHandler, deleteObjectHandler))))
+	mux.HandleFunc("POST /{bucket}/{key...}", limit("upload", s3Auth(RoleUpload, orBucket(notImplemented, postObjectHandler))))
+	return mux
+}
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+// ListBuckets lists the buckets of the cluster.
+func listBucketsHandler(w http.ResponseWriter, r *http.Request) {
+	buckets, err := s3Store.Buckets(r.Context())
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	user := principalFrom(r.Context()).User
+	result := listAllMyBucketsResult{Xmlns: s3Namespace, Owner: s3Owner{ID: user, DisplayName: user}}
+	for _, bucket := range buckets {
+		result.Buckets = append(result.Buckets, s3Bucket{Name: bucket.Name, CreationDate: bucket.CreatedAt.Format(s3TimeFormat)})
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// CreateBucket. Buckets are shared by the cluster; the region asked for
+// is ignored.
+func createBucketHandler(w http.ResponseWriter, r *http.Request) {
+	if unsupported(w, r) {
+		return
+	}
+	name := r.PathValue("bucket")
+	if !validBucketName(name) {
+		s3Error(w, r, http.StatusBadRequest, "InvalidBucketName", fmt.Sprintf("Invalid bucket name %q", name))
+		return
+	}
+	principal := principalFrom(r.Context())
+	existing, err := s3Store.Bucket(r.Context(), name)
+	if err == nil {
+		if existing.Owner == principal.User {
+			s3Error(w, r, http.StatusConflict, "BucketAlreadyOwnedByYou", fmt.Sprintf("Bucket %s already exists", name))
+		} else {
+			s3Error(w, r, http.StatusConflict, "BucketAlreadyExists", fmt.Sprintf("Bucket %s already exists", name))
+		}
+		return
+	}
+	if !errors.Is(err, datastore.ErrNotFound) {
+		writeS3Error(w, r, err)
+		return
+	}
+
+	data, err := json.Marshal(Bucket{Name: name, Owner: principal.User, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	if err := s3Store.buckets.Put(r.Context(), name, data); err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/"+name)
+	w.WriteHeader(http.StatusOK)
+}
+
+func headBucketHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := pathBucket(w, r); ok {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// DeleteBucket removes an empty bucket. Only its owner or an admin can.
+func deleteBucketHandler(w http.ResponseWriter, r *http.Request) {
+	if unsupported(w, r) {
+		return
+	}
+	bucket, ok := pathBucket(w, r)
+	if !ok {
+		return
+	}
+	if !canChange(r, bucket.Owner) {
+		s3Error(w, r, http.StatusForbidden, "AccessDenied", "Only the owner of a bucket or an admin can delete it")
+		return
+	}
+	objects, err := s3Store.Objects(r.Context(), bucket.Name)
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	if len(objects) > 0 {
+		s3Error(w, r, http.StatusConflict, "BucketNotEmpty", fmt.Sprintf("Bucket %s is not empty", bucket.Name))
+		return
+	}
+	if err := s3Store.buckets.Delete(r.Context(), bucket.Name); err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		writeS3Error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Xmlns                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int              `xml:"KeyCount"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Contents              []s3Content      `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type s3Content struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+	Owner        *s3Owner `xml:",omitempty"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+}
+
+// ListObjectsV2, and GetBucketLocation for the clients that look up the
+// region of buckets. Continuation tokens tell whether they resume after a
+// key or after a common prefix.
+func listObjectsHandler(w http.ResponseWriter, r *http.Request) {
+	if unsupported(w, r, "location") {
+		return
+	}
+	bucket, ok := pathBucket(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	if query.Has("location") {
+		writeXML(w, http.StatusOK, locationConstraint{Xmlns: s3Namespace})
+		return
+	}
+	if query.Get("list-type") != "2" {
+		s3Error(w, r, http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 is supported, with list-type=2")
+		return
+	}
+
+	result := listBucketResult{
+		Xmlns:             s3Namespace,
+		Name:              bucket.Name,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           maxS3Keys,
+		EncodingType:      query.Get("encoding-type"),
+	}
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s3Error(w, r, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("Invalid max-keys %q", v))
+			return
+		}
+		result.MaxKeys = min(n, maxS3Keys)
+	}
+	if result.EncodingType != "" && result.EncodingType != "url" {
+		s3Error(w, r, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("Invalid encoding-type %q", result.EncodingType))
+		return
+	}
+	var after, afterPrefix string
+	if result.ContinuationToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil || len(token) == 0 || token[0] != 'k' && token[0] != 'p' {
+			s3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid continuation token")
+			return
+		}
+		if token[0] == 'k' {
+			after = string(token[1:])
+		} else {
+			afterPrefix = string(token[1:])
+		}
+	}
+	fetchOwner := query.Get("fetch-owner") == "true"
+
+	objects, err := s3Store.Objects(r.Context(), bucket.Name)
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	escape := func(s string) string {
+		if result.EncodingType == "url" {
+			return url.QueryEscape(s)
+		}
+		return s
+	}
+	var last, lastPrefix string
+	for _, obj := range objects {
+		key := obj.Key
+		if !strings.HasPrefix(key, result.Prefix) || key <= result.StartAfter || key <= after ||
+			afterPrefix != "" && (key < afterPrefix || strings.HasPrefix(key, afterPrefix)) {
+			continue
+		}
+		// keys with the delimiter after the prefix are rolled up into a
+		// common prefix, which counts once
+		if result.Delimiter != "" {
+			if i := strings.Index(key[len(result.Prefix):], result.Delimiter); i >= 0 {
+				prefix := key[:len(result.Prefix)+i+len(result.Delimiter)]
+				if prefix == lastPrefix {
+					continue
+				}
+				if result.KeyCount == result.MaxKeys {
+					result.IsTruncated = result.MaxKeys > 0
+					break
+				}
+				result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: escape(prefix)})
+				result.KeyCount++
+				last, lastPrefix = "p"+prefix, prefix
+				continue
+			}
+		}
+		if result.KeyCount == result.MaxKeys {
+			result.IsTruncated = result.MaxKeys > 0
+			break
+		}
+		content := s3Content{
+			Key:          escape(key),
+			LastModified: obj.LastModified.Format(s3TimeFormat),
+			ETag:         obj.ETag,
+			Size:         obj.Size,
+			StorageClass: "STANDARD",
+		}
+		if fetchOwner {
+			content.Owner = &s3Owner{ID: obj.Owner, DisplayName: obj.Owner}
+		}
+		result.Contents = append(result.Contents, content)
+		result.KeyCount++
+		last = "k" + key
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+	result.Prefix = escape(result.Prefix)
+	result.Delimiter = escape(result.Delimiter)
+	result.StartAfter = escape(result.StartAfter)
+	writeXML(w, http.StatusOK, result)
+}
+
+// pathObject returns the object named in the request path. It writes the
+// error and returns false when it does not exist.
+func pathObject(w http.ResponseWriter, r *http.Request) (S3Object, bool) {
+	bucket, key, ok := pathObjectKey(w, r)
+	if !ok {
+		return S3Object{}, false
+	}
+	obj, err := s3Store.Object(r.Context(), bucket, key)
+	if errors.Is(err, datastore.ErrNotFound) {
+		s3Error(w, r, http.StatusNotFound, "NoSuchKey", fmt.Sprintf("Key %s does not exist", key))
+		return obj, false
+	}
+	if err != nil {
+		writeS3Error(w, r, err)
+		return obj, false
+	}
+	return obj, true
+}
+
+// objectHeaders sets the headers describing an object.
+func objectHeaders(w http.ResponseWriter, obj S3Object) {
+	w.Header().Set("ETag", obj.ETag)
+	w.Header().Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set(cidHeader, obj.CID)
+	for k, v := range obj.Metadata {
+		w.Header().Set("X-Amz-Meta-"+k, v)
+	}
+}
+
+func headObjectHandler(w http.ResponseWriter, r *http.Request) {
+	if unsupported(w, r) {
+		return
+	}
+	obj, ok := pathObject(w, r)
+	if !ok {
+		return
+	}
+	objectHeaders(w, obj)
+	w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetObject, with ranges and conditional requests.
+func getObjectHandler(w http.ResponseWriter, r *http.Request) {
+	if unsupported(w, r) {
+		return
+	}
+	obj, ok := pathObject(w, r)
+	if !ok {
+		return
+	}
+	c, err := cid.Decode(obj.CID)
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+
+	// Give up on finding the content after the retrieval timeout, like
+	// downloads through /files
+	ctx, cancel, ok := retrievalContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+	if _, err := ipfsNode.Get(ctx, c); err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+
+	var rsc io.ReadSeekCloser
+	if obj.Encrypted {
+		key, kerr := keyStore.Get(c)
+		if kerr != nil {
+			s3Error(w, r, http.StatusForbidden, "AccessDenied", "Object is encrypted and this node does not hold its key")
+			return
+		}
+		rsc, err = ipfsNode.GetEncryptedFile(r.Context(), c, key)
+	} else {
+		rsc, err = ipfsNode.GetFile(r.Context(), c)
+	}
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	defer rsc.Close()
+
+	objectHeaders(w, obj)
+	http.ServeContent(w, r, "", obj.LastModified, rsc)
+}
+
+// objectMetadata returns the x-amz-meta-* headers of r, by lowercase name.
+func objectMetadata(r *http.Request) map[string]string {
+	var metadata map[string]string
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+		if k, ok := strings.CutPrefix(name, "x-amz-meta-"); ok && len(values) > 0 {
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[k] = values[0]
+		}
+	}
+	return metadata
+}
+
+// newObject describes the object of the request path from its headers,
+// before its content is received.
+func newObject(w http.ResponseWriter, r *http.Request, bucket, key string) (S3Object, bool) {
+	obj := S3Object{
+		Bucket:      bucket,
+		Key:         key,
+		ContentType: r.Header.Get("Content-Type"),
+		Metadata:    objectMetadata(r),
+	}
+	if obj.ContentType == "" {
+		obj.ContentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if obj.ContentType == "" {
+		obj.ContentType = "binary/octet-stream"
+	}
+	if problems := validateMetadata(FileInfo{Metadata: obj.Metadata}); len(problems) > 0 {
+		s3Error(w, r, http.StatusBadRequest, "MetadataTooLarge", problems["metadata"])
+		return obj, false
+	}
+	return obj, true
+}
+
+// PutObject, or UploadPart with the uploadId and partNumber parameters.
+// Objects are received in full before being imported, which checks their
+// digests and spares the node incomplete uploads.
+func putObjectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("uploadId") {
+		uploadPartHandler(w, r)
+		return
+	}
+	if unsupported(w, r) {
+		return
+	}
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		s3Error(w, r, http.StatusNotImplemented, "NotImplemented", "CopyObject is not supported")
+		return
+	}
+	bucket, key, ok := pathObjectKey(w, r)
+	if !ok || !writeAllowed(w, r, bucket, key) {
+		return
+	}
+	obj, ok := newObject(w, r, bucket, key)
+	if !ok {
+		return
+	}
+
+	body, err := s3Store.spool(r, *s3MaxSize)
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	defer s3Store.remove(body)
+	obj.ETag = fmt.Sprintf("%q", hex.EncodeToString(body.md5))
+
+	obj, err = s3Store.put(r.Context(), principalFrom(r.Context()), obj, body.File)
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", obj.ETag)
+	w.Header().Set(cidHeader, obj.CID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteObject, or AbortMultipartUpload with the uploadId parameter. The
+// versions of the object are removed from the catalog; content that other
+// entries have stays pinned. Only the owner of the
+// object or of its bucket, or an admin, can delete it. Deleting a missing
+// key succeeds.
+func deleteObjectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("uploadId") {
+		abortMultipartUploadHandler(w, r)
+		return
+	}
+	if unsupported(w, r) {
+		return
+	}
+	bucket, key, ok := pathObjectKey(w, r)
+	if !ok {
+		return
+	}
+	_, err := s3Store.Object(r.Context(), bucket, key)
+	if errors.Is(err, datastore.ErrNotFound) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	if !writeAllowed(w, r, bucket, key) {
+		return
+	}
+
+	versions, err := catalog.Versions(r.Context(), objectFilename(bucket, key))
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	for _, fileInfo := range versions {
+		if _, err := removeFile(r.Context(), fileInfo); err != nil {
+			writeS3Error(w, r, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateMultipartUpload with the uploads parameter, or
+// CompleteMultipartUpload with the uploadId parameter.
+func postObjectHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case query.Has("uploads"):
+		createMultipartUploadHandler(w, r)
+	case query.Has("uploadId"):
+		completeMultipartUploadHandler(w, r)
+	default:
+		s3Error(w, r, http.StatusNotImplemented, "NotImplemented", "Only multipart uploads are supported by POST")
+	}
+}
+
+// spooledBody is a request body saved to a temporary file, open at its
+// start. Its size is charged to the quotas of scopes until it is removed.
+type spooledBody struct {
+	*os.File
+	size   int64
+	md5    []byte
+	scopes []string
+}
+
+// remove closes and deletes the file of a body and releases its size.
+func (s *S3Store) remove(b *spooledBody) {
+	b.Close()
+	os.Remove(b.Name())
+	s.release(b.scopes, b.size)
+}
+
+// reserve charges size bytes being received for the principal to its
+// quotas until they are released, so that bodies and parts waiting on
+// disk cannot exceed them. It returns the quota scopes charged.
+func (s *S3Store) reserve(ctx context.Context, principal *Principal, size int64) ([]string, error) {
+	room, err := accountant.Room(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var scopes []string
+	for scope, n := range room {
+		if s.pending[scope]+size > n {
+			return nil, newS3Error(http.StatusRequestEntityTooLarge, "QuotaExceeded",
+				fmt.Sprintf("Quota exceeded: %s has room for %d more bytes, %d of them taken by uploads in progress", scope, n, s.pending[scope]))
+		}
+		scopes = append(scopes, scope)
+	}
+	for _, scope := range scopes {
+		s.pending[scope] += size
+	}
+	return scopes, nil
+}
+
+func (s *S3Store) release(scopes []string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, scope := range scopes {
+		if s.pending[scope] -= size; s.pending[scope] <= 0 {
+			delete(s.pending, scope)
+		}
+	}
+}
+
+// spool saves the body of r, of at most limit bytes, to a temporary file
+// and checks it against the Content-MD5 and X-Amz-Content-Sha256 headers.
+// Bodies streamed with the aws-chunked encoding are decoded. The size of
+// the body must be given, by X-Amz-Decoded-Content-Length for streamed
+// bodies or else by Content-Length, and is charged to the quotas of the
+// principal before the body is read.
+func (s *S3Store) spool(r *http.Request, limit int64) (*spooledBody, error) {
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	streaming := strings.HasPrefix(payloadHash, "STREAMING-") || strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked")
+	var content io.Reader = r.Body
+	size := r.ContentLength
+	if streaming {
+		content = newAWSChunkedReader(r.Body)
+		var err error
+		if size, err = strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err != nil {
+			size = -1
+		}
+	}
+	if size < 0 {
+		return nil, newS3Error(http.StatusLengthRequired, "MissingContentLength", "The size of the body must be given by Content-Length or X-Amz-Decoded-Content-Length")
+	}
+	if size > limit {
+		return nil, newS3Error(http.StatusBadRequest, "EntityTooLarge", fmt.Sprintf("The body is larger than the %d bytes left for the object", limit))
+	}
+	scopes, err := s.reserve(r.Context(), principalFrom(r.Context()), size)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(s.dir, "body-")
+	if err != nil {
+		s.release(scopes, size)
+		return nil, err
+	}
+	body := &spooledBody{File: f, size: size, scopes: scopes}
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, md5Hash, sha256Hash), io.LimitReader(content, size+1))
+	if err != nil {
+		s.remove(body)
+		return nil, newS3Error(http.StatusBadRequest, "IncompleteBody", fmt.Sprintf("Error reading the body: %s", err.Error()))
+	}
+	body.md5 = md5Hash.Sum(nil)
+
+	fail := func(code, message string) (*spooledBody, error) {
+		s.remove(body)
+		return nil, newS3Error(http.StatusBadRequest, code, message)
+	}
+	if n != size {
+		return fail("IncompleteBody", "The body is shorter or longer than its declared size")
+	}
+	if v := r.Header.Get("Content-MD5"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(sum) != md5.Size {
+			return fail("InvalidDigest", "Invalid Content-MD5")
+		}
+		if !slices.Equal(sum, body.md5) {
+			return fail("BadDigest", "The body does not match Content-MD5")
+		}
+	}
+	if sum, err := hex.DecodeString(payloadHash); err == nil && len(sum) == sha256.Size && !streaming {
+		if !slices.Equal(sum, sha256Hash.Sum(nil)) {
+			return fail("XAmzContentSHA256Mismatch", "The body does not match X-Amz-Content-Sha256")
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		s.remove(body)
+		return nil, err
+	}
+	return body, nil
+}
+
+// awsChunkedReader decodes the aws-chunked content encoding of streamed
+// uploads: chunks of "<hex size>[;chunk-signature=<signature>]\r\n<data>\r\n",
+// ended by an empty chunk and optional trailing headers, such as
+// checksums. Chunk signatures and trailers are not checked.
+type awsChunkedReader struct {
+	r    *bufio.Reader
+	left int64 // bytes left in the current chunk
+	crlf bool  // whether the CRLF ending a chunk is still to be read
+	err  error
+}
+
+func newAWSChunkedReader(r io.Reader) *awsChunkedReader {
+	return &awsChunkedReader{r: bufio.NewReader(r)}
+}
+
+var errMalformedChunk = errors.New("malformed aws-chunked encoding")
+
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.left == 0 {
+		if c.err = c.next(); c.err != nil {
+			return 0, c.err
+		}
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+// next reads the header of the next chunk. It returns io.EOF after the
+// last one.
+func (c *awsChunkedReader) next() error {
+	line, err := c.line()
+	if err != nil {
+		return err
+	}
+	if c.crlf {
+		if line != "" {
+			return errMalformedChunk
+		}
+		if line, err = c.line(); err != nil {
+			return err
+		}
+	}
+	sizeField, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return errMalformedChunk
+	}
+	if size == 0 {
+		// skip the trailers, up to the empty line ending them
+		for {
+			line, err := c.line()
+			if err == io.ErrUnexpectedEOF || err == nil && line == "" {
+				return io.EOF
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	c.left, c.crlf = size, true
+	return nil
+}
+
+// line reads a line, without its CRLF.
+func (c *awsChunkedReader) line() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// newUploadID returns a random ID for a multipart upload.
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newTestS3 starts a test node serving the S3 API, with alice and bob as
+// uploaders and root as admin, authenticated by their name as token.
+func newTestS3(t *testing.T) (context.Context, http.Handler) {
+	t.Helper()
+	ctx := newTestCatalog(t)
+	var err error
+	if pinTracker, err = setUpPinTracker(ctx); err != nil {
+		t.Fatal(err)
+	}
+	accountant = NewAccountant(&Quotas{users: make(map[string]Quota), groups: make(map[string]Quota)})
+	if s3Store, err = setUpS3(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(s3Store.dir)
+		s3Store = nil
+	})
+
+	a, err := NewTokenAuthenticator(writeTestFile(t, "tokens", "alice alice upload\nbob bob upload\nroot root admin\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticators = []Authenticator{a}
+	t.Cleanup(func() { authenticators = nil })
+
+	return ctx, s3Handler(func(class string, next http.HandlerFunc) http.HandlerFunc { return next })
+}
+
+// s3Step is a request of a user to the S3 API and the status it gets.
+type s3Step struct {
+	user   string
+	method string
+	path   string
+	body   string
+	status int
+}
+
+func (step s3Step) run(t *testing.T, h http.Handler) *httptest.ResponseRecorder {
+	t.Helper()
+	var body io.Reader
+	if step.body != "" {
+		body = strings.NewReader(step.body)
+	}
+	r := httptest.NewRequest(step.method, step.path, body)
+	r.Header.Set("Authorization", "Bearer "+step.user)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != step.status {
+		t.Fatalf("%s %s %s: status = %d, want %d: %s", step.user, step.method, step.path, w.Code, step.status, w.Body)
+	}
+	return w
+}
+
+func TestS3ObjectOwnership(t *testing.T) {
+	ctx, h := newTestS3(t)
+	s3Step{"alice", http.MethodPut, "/shared", "", http.StatusOK}.run(t, h)
+	s3Step{"alice", http.MethodPut, "/shared/a.txt", "by alice", http.StatusOK}.run(t, h)
+	// bob owns an object of the bucket of alice, as before ownership was
+	// checked
+	if _, err := s3Store.put(ctx, &Principal{User: "bob"}, S3Object{Bucket: "shared", Key: "b.txt"}, strings.NewReader("by bob")); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name  string
+		step  s3Step
+		owner string // of the object afterwards, "" when deleted
+	}{
+		{"other user overwrites", s3Step{"bob", http.MethodPut, "/shared/a.txt", "by bob", http.StatusForbidden}, "alice"},
+		{"other user adds a key", s3Step{"bob", http.MethodPut, "/shared/c.txt", "by bob", http.StatusForbidden}, ""},
+		{"other user starts a multipart upload", s3Step{"bob", http.MethodPost, "/shared/a.txt?uploads", "", http.StatusForbidden}, "alice"},
+		{"other user deletes", s3Step{"bob", http.MethodDelete, "/shared/a.txt", "", http.StatusForbidden}, "alice"},
+		{"object owner overwrites", s3Step{"bob", http.MethodPut, "/shared/b.txt", "by bob again", http.StatusOK}, "bob"},
+		{"bucket owner overwrites", s3Step{"alice", http.MethodPut, "/shared/a.txt", "by alice again", http.StatusOK}, "alice"},
+		{"admin overwrites", s3Step{"root", http.MethodPut, "/shared/a.txt", "by root", http.StatusOK}, "root"},
+		{"bucket owner deletes an object of another", s3Step{"alice", http.MethodDelete, "/shared/a.txt", "", http.StatusNoContent}, ""},
+		{"object owner deletes", s3Step{"bob", http.MethodDelete, "/shared/b.txt", "", http.StatusNoContent}, ""},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.step.run(t, h)
+			key := strings.TrimPrefix(tt.step.path, "/shared/")
+			key, _, _ = strings.Cut(key, "?")
+			obj, err := s3Store.Object(ctx, "shared", key)
+			if tt.owner == "" {
+				if err == nil {
+					t.Errorf("object %s exists, owned by %s", key, obj.Owner)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if obj.Owner != tt.owner {
+				t.Errorf("owner = %s, want %s", obj.Owner, tt.owner)
+			}
+		})
+	}
+}
+
+func TestS3ObjectsInCatalog(t *testing.T) {
+	ctx, h := newTestS3(t)
+	s3Step{"alice", http.MethodPut, "/photos", "", http.StatusOK}.run(t, h)
+	s3Step{"alice", http.MethodPut, "/photos/a.txt", "object of alice", http.StatusOK}.run(t, h)
+	s3Step{"alice", http.MethodPut, "/photos/b.txt", "another object of alice", http.StatusOK}.run(t, h)
+	// an upload named like the object, with the same content
+	addTestFile(t, ctx, FileInfo{Filename: "photos/a.txt", Owner: "bob"}, "object of alice")
+
+	s3Step{"alice", http.MethodDelete, "/photos/a.txt", "", http.StatusNoContent}.run(t, h)
+	if uploads, err := catalog.Versions(ctx, "photos/a.txt"); err != nil || len(uploads) != 1 {
+		t.Errorf("uploads named photos/a.txt = %v (%v), want the one of bob", uploads, err)
+	}
+
+	// removing the catalog entry of an object removes the object
+	versions, err := catalog.Versions(ctx, objectFilename("photos", "b.txt"))
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("versions of the object = %v (%v), want one", versions, err)
+	}
+	if _, err := removeFile(ctx, versions[0]); err != nil {
+		t.Fatal(err)
+	}
+	if obj, err := s3Store.Object(ctx, "photos", "b.txt"); err == nil {
+		t.Errorf("object b.txt still exists: %+v", obj)
+	}
+	objects, err := s3Store.Objects(ctx, "photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("objects = %+v, want none", objects)
+	}
+}
+
+func TestS3UploadLimits(t *testing.T) {
+	defer func(size int64) { *s3MaxSize = size }(*s3MaxSize)
+	*s3MaxSize = 50
+	_, h := newTestS3(t)
+	accountant = NewAccountant(&Quotas{users: map[string]Quota{"alice": {Bytes: 70}}, groups: make(map[string]Quota)})
+	s3Step{"alice", http.MethodPut, "/big", "", http.StatusOK}.run(t, h)
+
+	w := s3Step{"alice", http.MethodPost, "/big/parts.bin?uploads", "", http.StatusOK}.run(t, h)
+	var created initiateMultipartUploadResult
+	if err := xml.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	part := func(n int) string {
+		return fmt.Sprintf("/big/parts.bin?uploadId=%s&partNumber=%d", created.UploadId, n)
+	}
+
+	steps := []struct {
+		name    string
+		step    s3Step
+		pending int64 // of alice afterwards
+	}{
+		{"object over the size limit", s3Step{"alice", http.MethodPut, "/big/a.bin", strings.Repeat("a", 51), http.StatusBadRequest}, 0},
+		{"part within the size limit", s3Step{"alice", http.MethodPut, part(1), strings.Repeat("1", 40), http.StatusOK}, 40},
+		{"parts over the size limit together", s3Step{"alice", http.MethodPut, part(2), strings.Repeat("2", 11), http.StatusBadRequest}, 40},
+		{"part replaced", s3Step{"alice", http.MethodPut, part(1), strings.Repeat("1", 30), http.StatusOK}, 30},
+		{"part up to the size limit", s3Step{"alice", http.MethodPut, part(2), strings.Repeat("2", 20), http.StatusOK}, 50},
+		{"object over the quota left by the parts", s3Step{"alice", http.MethodPut, "/big/a.bin", strings.Repeat("a", 21), http.StatusRequestEntityTooLarge}, 50},
+		{"upload aborted", s3Step{"alice", http.MethodDelete, "/big/parts.bin?uploadId=" + created.UploadId, "", http.StatusNoContent}, 0},
+		{"object within the quota once the parts are gone", s3Step{"alice", http.MethodPut, "/big/a.bin", strings.Repeat("a", 21), http.StatusOK}, 0},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.step.run(t, h)
+			s3Store.mu.Lock()
+			pending := s3Store.pending["user alice"]
+			s3Store.mu.Unlock()
+			if pending != tt.pending {
+				t.Errorf("pending = %d, want %d", pending, tt.pending)
+			}
+		})
+	}
+
+	t.Run("size not given", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPut, "/big/b.bin", strings.NewReader("b"))
+		r.ContentLength = -1
+		r.Header.Set("Authorization", "Bearer alice")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusLengthRequired {
+			t.Errorf("status = %d, want %d: %s", w.Code, http.StatusLengthRequired, w.Body)
+		}
+	})
+}
+
+func TestS3PutBackParts(t *testing.T) {
+	tests := []struct {
+		name    string
+		aborted bool
+	}{
+		{"upload still open", false},
+		{"upload aborted during the completion", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestS3(t)
+			path := writeTestFile(t, "part", "content of the part")
+			parts := map[int]uploadedPart{1: {path: path, size: 19, scopes: []string{"user alice"}}}
+			s3Store.pending["user alice"] = 19
+			// a completion took the parts
+			u := &multipartUpload{ID: "upload"}
+			s3Store.uploads[u.ID] = u
+
+			if tt.aborted {
+				s3Store.abort(u)
+			}
+			s3Store.putBack(u, parts)
+
+			_, listed := s3Store.uploads[u.ID]
+			_, err := os.Stat(path)
+			kept := err == nil
+			if listed != !tt.aborted || kept != !tt.aborted || (u.parts != nil) != !tt.aborted {
+				t.Errorf("listed = %v, parts kept = %v on disk and %v in the upload, want %v", listed, kept, u.parts != nil, !tt.aborted)
+			}
+			want := int64(19)
+			if tt.aborted {
+				want = 0
+			}
+			if n := s3Store.pending["user alice"]; n != want {
+				t.Errorf("pending = %d, want %d", n, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// maxClockSkew is how far the time of a signed request may be from the
+	// time of the node.
+	maxClockSkew = 15 * time.Minute
+	// maxPresignedExpiry is the longest validity of a presigned URL.
+	maxPresignedExpiry = 7 * 24 * time.Hour
+)
+
+// Errors of S3 signatures, mapped to S3 error codes.
+var (
+	errUnknownAccessKey  = fmt.Errorf("%w: unknown access key", errInvalidCredentials)
+	errSignatureMismatch = fmt.Errorf("%w: signature does not match", errInvalidCredentials)
+	errRequestExpired    = fmt.Errorf("%w: request has expired or is not yet valid", errInvalidCredentials)
+)
+
+// S3Authenticator accepts requests signed with AWS Signature Version 4, as
+// sent by S3 clients and SDKs, either in the Authorization header or as a
+// presigned URL. The keys are listed in a file, one per line as
+// "<access key> <secret key> <user> <role>[,<role>...] [<group>[,<group>...]]".
+// Blank lines and lines starting with # are ignored.
+//
+// The signature covers the method, path, query and signed headers. The
+// payload is covered by its SHA-256 when the client signs it, which the S3
+// handlers check against the body; streamed chunk signatures are not
+// checked, so use TLS when the network is not trusted.
+type S3Authenticator struct {
+	keys map[string]s3Key
+}
+
+type s3Key struct {
+	secret    string
+	principal *Principal
+}
+
+func NewS3Authenticator(path string) (*S3Authenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	a := &S3Authenticator{keys: make(map[string]s3Key)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("malformed S3 key line: %q", line)
+		}
+		principal := &Principal{
+			User:  fields[2],
+			Roles: strings.Split(fields[3], ","),
+		}
+		if len(fields) > 4 {
+			principal.Groups = strings.Split(fields[4], ",")
+		}
+		a.keys[fields[0]] = s3Key{secret: fields[1], principal: principal}
+	}
+	return a, scanner.Err()
+}
+
+func (a *S3Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	sig, err := parseSigV4(r)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := a.keys[sig.accessKey]
+	if !ok {
+		return nil, errUnknownAccessKey
+	}
+	if !hmac.Equal([]byte(sig.signature), []byte(sig.sign(key.secret, r))) {
+		return nil, errSignatureMismatch
+	}
+	return key.principal, nil
+}
+
+// sigV4 is the signature of a request and what it was computed over.
+type sigV4 struct {
+	accessKey     string
+	scope         string // <date>/<region>/<service>/aws4_request
+	date          string // yyyymmdd of the scope
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	time          string // X-Amz-Date
+	payloadHash   string
+	presigned     bool
+}
+
+// parseSigV4 reads the signature from the Authorization header or from the
+// query of a presigned URL, and checks that the request is within its
+// validity. It returns errNoCredentials for requests that are not signed.
+func parseSigV4(r *http.Request) (*sigV4, error) {
+	sig := &sigV4{}
+	var credential, signedHeaders string
+	query := r.URL.Query()
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, sigV4Algorithm+" ") {
+		for _, part := range strings.Split(strings.TrimPrefix(h, sigV4Algorithm+" "), ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				sig.signature = value
+			}
+		}
+		sig.time = r.Header.Get("X-Amz-Date")
+		sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	} else if query.Get("X-Amz-Algorithm") == sigV4Algorithm {
+		sig.presigned = true
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		sig.signature = query.Get("X-Amz-Signature")
+		sig.time = query.Get("X-Amz-Date")
+		sig.payloadHash = query.Get("X-Amz-Content-Sha256")
+	} else {
+		return nil, errNoCredentials
+	}
+	// S3 clients send the hash of the payload, or UNSIGNED-PAYLOAD; other
+	// clients sign the hash of the body, which is known when it is empty
+	if sig.payloadHash == "" {
+		sig.payloadHash = unsignedPayload
+		if !sig.presigned && r.ContentLength == 0 {
+			sig.payloadHash = hexSHA256(nil)
+		}
+	}
+
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || signedHeaders == "" || sig.signature == "" {
+		return nil, fmt.Errorf("%w: malformed signature", errInvalidCredentials)
+	}
+	sig.accessKey, sig.date, sig.region, sig.service = parts[0], parts[1], parts[2], parts[3]
+	sig.scope = strings.Join(parts[1:], "/")
+	sig.signedHeaders = strings.Split(signedHeaders, ";")
+
+	t, err := time.Parse(sigV4TimeFormat, sig.time)
+	if err != nil || !strings.HasPrefix(sig.time, sig.date) {
+		return nil, fmt.Errorf("%w: malformed X-Amz-Date", errInvalidCredentials)
+	}
+	now := time.Now()
+	if sig.presigned {
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignedExpiry {
+			return nil, fmt.Errorf("%w: malformed X-Amz-Expires", errInvalidCredentials)
+		}
+		if now.Before(t.Add(-maxClockSkew)) || now.After(t.Add(time.Duration(expires)*time.Second)) {
+			return nil, errRequestExpired
+		}
+	} else if now.Sub(t) > maxClockSkew || t.Sub(now) > maxClockSkew {
+		return nil, errRequestExpired
+	}
+	return sig, nil
+}
+
+// sign computes the signature of r with secret.
+func (sig *sigV4) sign(secret string, r *http.Request) string {
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		sig.time,
+		sig.scope,
+		hexSHA256([]byte(sig.canonicalRequest(r))),
+	}, "\n")
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{sig.date, sig.region, sig.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func (sig *sigV4) canonicalRequest(r *http.Request) string {
+	// the query, without the signature itself when presigned
+	var pairs [][2]string
+	for name, values := range r.URL.Query() {
+		if sig.presigned && name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, [2]string{awsEscape(name, true), awsEscape(value, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] == pairs[j][0] {
+			return pairs[i][1] < pairs[j][1]
+		}
+		return pairs[i][0] < pairs[j][0]
+	})
+	params := make([]string, len(pairs))
+	for i, pair := range pairs {
+		params[i] = pair[0] + "=" + pair[1]
+	}
+
+	var headers strings.Builder
+	for _, name := range sig.signedHeaders {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			var values []string
+			for _, v := range r.Header.Values(name) {
+				values = append(values, strings.Join(strings.Fields(v), " "))
+			}
+			value = strings.Join(values, ",")
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		r.Method,
+		awsEscape(path, false),
+		strings.Join(params, "&"),
+		headers.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+}
+
+// awsEscape percent-encodes s the way AWS signatures do: every byte but
+// unreserved characters, and slashes too when escapeSlash is set.
+func awsEscape(s string, escapeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
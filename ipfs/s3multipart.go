@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxPartNumber = 10000
+
+	// multipartExpiry is how long a multipart upload may stay incomplete
+	// before its parts are deleted.
+	multipartExpiry = 24 * time.Hour
+)
+
+// multipartUpload is an object received in parts, which are kept in
+// temporary files until the upload is completed or aborted.
+type multipartUpload struct {
+	ID        string
+	Object    S3Object // without content yet
+	Initiated time.Time
+
+	mu      sync.Mutex
+	parts   map[int]uploadedPart // nil while the upload is being completed
+	removed bool                 // aborted or expired
+}
+
+// uploadedPart is a part on disk, charged to the quotas of scopes until it
+// is removed.
+type uploadedPart struct {
+	path   string
+	size   int64
+	md5    []byte
+	scopes []string
+}
+
+func (s *S3Store) removePart(part uploadedPart) {
+	os.Remove(part.path)
+	s.release(part.scopes, part.size)
+}
+
+// abort removes a multipart upload and its parts. Parts taken by a
+// completion in progress are removed when it ends.
+func (s *S3Store) abort(u *multipartUpload) {
+	s.mu.Lock()
+	if s.uploads[u.ID] == u {
+		delete(s.uploads, u.ID)
+	}
+	s.mu.Unlock()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, part := range u.parts {
+		s.removePart(part)
+	}
+	u.parts = nil
+	u.removed = true
+}
+
+// upload returns the multipart upload of the request, checking that it is
+// for the object of the request path and that the principal started it.
+func (s *S3Store) upload(w http.ResponseWriter, r *http.Request) (*multipartUpload, bool) {
+	bucket, key, ok := pathObjectKey(w, r)
+	if !ok {
+		return nil, false
+	}
+	id := r.URL.Query().Get("uploadId")
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	s.mu.Unlock()
+	if !ok || u.Object.Bucket != bucket || u.Object.Key != key {
+		s3Error(w, r, http.StatusNotFound, "NoSuchUpload", fmt.Sprintf("Upload %s does not exist on this node", id))
+		return nil, false
+	}
+	if !canChange(r, u.Object.Owner) {
+		s3Error(w, r, http.StatusForbidden, "AccessDenied", "Only the user who started an upload or an admin can change it")
+		return nil, false
+	}
+	return u, true
+}
+
+// expireUploads aborts the multipart uploads left incomplete for longer
+// than multipartExpiry.
+func (s *S3Store) expireUploads(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		var expired []*multipartUpload
+		for _, u := range s.uploads {
+			if time.Since(u.Initiated) > multipartExpiry {
+				expired = append(expired, u)
+			}
+		}
+		s.mu.Unlock()
+		for _, u := range expired {
+			s.abort(u)
+			fmt.Printf("expired multipart upload %s of %s\n", u.ID, objectFilename(u.Object.Bucket, u.Object.Key))
+		}
+	}
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+// CreateMultipartUpload. The parts and the completion must be sent to the
+// same node.
+func createMultipartUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if unsupported(w, r, "uploads") {
+		return
+	}
+	bucket, key, ok := pathObjectKey(w, r)
+	if !ok || !writeAllowed(w, r, bucket, key) {
+		return
+	}
+	obj, ok := newObject(w, r, bucket, key)
+	if !ok {
+		return
+	}
+	obj.Owner = principalFrom(r.Context()).User
+	id, err := newUploadID()
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+
+	s3Store.mu.Lock()
+	s3Store.uploads[id] = &multipartUpload{ID: id, Object: obj, Initiated: time.Now(), parts: make(map[int]uploadedPart)}
+	s3Store.mu.Unlock()
+
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadId: id})
+}
+
+// UploadPart. Sending a part number again replaces the part. The parts of
+// an upload together cannot exceed -s3-max-object-size.
+func uploadPartHandler(w http.ResponseWriter, r *http.Request) {
+	if unsupported(w, r, "uploadId", "partNumber") {
+		return
+	}
+	u, ok := s3Store.upload(w, r)
+	if !ok {
+		return
+	}
+	v := r.URL.Query().Get("partNumber")
+	number, err := strconv.Atoi(v)
+	if err != nil || number < 1 || number > maxPartNumber {
+		s3Error(w, r, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("Invalid partNumber %q, expected 1 to %d", v, maxPartNumber))
+		return
+	}
+
+	noSuchUpload := func() {
+		s3Error(w, r, http.StatusNotFound, "NoSuchUpload", fmt.Sprintf("Upload %s does not exist on this node or is being completed", u.ID))
+	}
+	u.mu.Lock()
+	if u.parts == nil {
+		u.mu.Unlock()
+		noSuchUpload()
+		return
+	}
+	left := *s3MaxSize - u.otherPartsSize(number)
+	u.mu.Unlock()
+
+	body, err := s3Store.spool(r, left)
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	body.Close()
+	part := uploadedPart{path: body.Name(), size: body.size, md5: body.md5, scopes: body.scopes}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.parts == nil {
+		// completed or aborted meanwhile
+		s3Store.removePart(part)
+		noSuchUpload()
+		return
+	}
+	if u.otherPartsSize(number)+part.size > *s3MaxSize {
+		// other parts were received meanwhile
+		s3Store.removePart(part)
+		s3Error(w, r, http.StatusBadRequest, "EntityTooLarge", fmt.Sprintf("The parts of the upload are larger than %d bytes", *s3MaxSize))
+		return
+	}
+	if old, ok := u.parts[number]; ok {
+		s3Store.removePart(old)
+	}
+	u.parts[number] = part
+
+	w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(part.md5)))
+	w.WriteHeader(http.StatusOK)
+}
+
+// otherPartsSize returns the size of the parts other than number. The
+// upload must be locked.
+func (u *multipartUpload) otherPartsSize(number int) int64 {
+	var size int64
+	for n, part := range u.parts {
+		if n != number {
+			size += part.size
+		}
+	}
+	return size
+}
+
+// putBack returns the parts taken by a completion that failed to their
+// upload, or removes them if the upload was aborted or expired meanwhile.
+func (s *S3Store) putBack(u *multipartUpload, parts map[int]uploadedPart) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.removed {
+		u.parts = parts
+		return
+	}
+	for _, part := range parts {
+		s.removePart(part)
+	}
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+// CompleteMultipartUpload imports the listed parts, in order, as the
+// object. Its ETag is the MD5 of the MD5s of the parts followed by the
+// number of parts, like in S3.
+func completeMultipartUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if unsupported(w, r, "uploadId") {
+		return
+	}
+	u, ok := s3Store.upload(w, r)
+	// the object may have changed hands since the upload started
+	if !ok || !writeAllowed(w, r, u.Object.Bucket, u.Object.Key) {
+		return
+	}
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxS3XMLBody)).Decode(&req); err != nil || len(req.Parts) == 0 {
+		s3Error(w, r, http.StatusBadRequest, "MalformedXML", "Expected a CompleteMultipartUpload listing the parts")
+		return
+	}
+
+	// Take the parts, so that the upload is completed once and parts
+	// cannot be replaced while they are read. They are put back for
+	// another try if they are wrong or the import fails, unless the upload
+	// was aborted or expired meanwhile.
+	u.mu.Lock()
+	parts := u.parts
+	u.parts = nil
+	u.mu.Unlock()
+	if parts == nil {
+		s3Error(w, r, http.StatusNotFound, "NoSuchUpload", fmt.Sprintf("Upload %s does not exist on this node or is being completed", u.ID))
+		return
+	}
+	putBack := func() { s3Store.putBack(u, parts) }
+	fail := func(err error) {
+		putBack()
+		writeS3Error(w, r, err)
+	}
+
+	var paths []string
+	sums := md5.New()
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			putBack()
+			s3Error(w, r, http.StatusBadRequest, "InvalidPartOrder", "Parts must be listed in ascending order")
+			return
+		}
+		part, ok := parts[p.PartNumber]
+		if !ok || strings.Trim(p.ETag, `"`) != hex.EncodeToString(part.md5) {
+			putBack()
+			s3Error(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part %d was not uploaded or its ETag does not match", p.PartNumber))
+			return
+		}
+		paths = append(paths, part.path)
+		sums.Write(part.md5)
+	}
+
+	var files []io.Reader
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			fail(err)
+			return
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	obj := u.Object
+	obj.ETag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sums.Sum(nil)), len(req.Parts))
+	obj, err := s3Store.put(r.Context(), principalFrom(r.Context()), obj, io.MultiReader(files...))
+	if err != nil {
+		fail(err)
+		return
+	}
+	// the upload is done
+	s3Store.abort(u)
+	for _, part := range parts {
+		s3Store.removePart(part)
+	}
+
+	w.Header().Set(cidHeader, obj.CID)
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: r.URL.Path,
+		Bucket:   obj.Bucket,
+		Key:      obj.Key,
+		ETag:     obj.ETag,
+	})
+}
+
+// AbortMultipartUpload deletes the parts received.
+func abortMultipartUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if unsupported(w, r, "uploadId") {
+		return
+	}
+	u, ok := s3Store.upload(w, r)
+	if !ok {
+		return
+	}
+	s3Store.abort(u)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	ipfslite "ipfs-demo/ipfs"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultShareTTL = 24 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+	maxShareRequest = 4 << 10
+	shareSessionTTL = 6 * time.Hour
+
+	shareSessionCookie = "share_session"
+)
+
+// Share is a link that lets anyone download a single file without API
+// access, until it expires, runs out of downloads or is revoked.
+type Share struct {
+	ID           string    `json:"id"`
+	CID          string    `json:"cid"`
+	Filename     string    `json:"filename,omitempty"`
+	CreatedBy    string    `json:"createdBy"`
+	CreatedAt    time.Time `json:"createdAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	MaxDownloads int       `json:"maxDownloads,omitempty"` // 0 for no limit
+	Password     bool      `json:"password"`               // whether the link asks for a password
+
+	PasswordHash []byte `json:"passwordHash,omitempty"` // bcrypt, never sent to clients
+}
+
+// shareClaims is the signed payload of a share token. It repeats the terms
+// of the share so that a token cannot be reused for another file or past
+// its expiry, even if the share record were altered.
+type shareClaims struct {
+	ID           string `json:"i"`
+	CID          string `json:"c"`
+	ExpiresAt    int64  `json:"e"`
+	MaxDownloads int    `json:"m,omitempty"`
+	Password     bool   `json:"p,omitempty"`
+}
+
+// ShareLink is a share as returned by the API, with its token and URL.
+type ShareLink struct {
+	Share
+	Downloads int    `json:"downloads"`
+	Token     string `json:"token"`
+	URL       string `json:"url"`
+}
+
+// ShareRequest is the body of POST /shares. ExpiresIn is a duration such as
+// "72h", 24h by default and at most 30 days.
+type ShareRequest struct {
+	CID          string `json:"cid"`
+	ExpiresIn    string `json:"expiresIn"`
+	Password     string `json:"password"`
+	MaxDownloads int    `json:"maxDownloads"`
+}
+
+// shares holds the share links of the cluster.
+var shares *Shares
+
+// Shares keeps the share links of the cluster. Links are records in a
+// CRDT store, so any node can serve and revoke them, and tokens are signed
+// with a key that the nodes must share. Each node counts the downloads it
+// serves under its own keys, so counts never conflict; a download limit
+// can thus be overrun by downloads running at once on several nodes.
+type Shares struct {
+	key       []byte
+	records   *ipfslite.CRDT
+	downloads *ipfslite.CRDT
+
+	mu sync.Mutex // serializes the download counts of this node
+}
+
+func sharesTopic() string {
+	return "ipfs-demo/shares/" + *clusterName
+}
+
+func shareDownloadsTopic() string {
+	return "ipfs-demo/sharedownloads/" + *clusterName
+}
+
+// loadShareKey reads the signing key of share tokens. Without a file, a
+// random key is made, and links only work on this node until it restarts.
+func loadShareKey(path string) ([]byte, error) {
+	if path == "" {
+		fmt.Println("no -share-key given, share links will only work on this node until it restarts")
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		return key, err
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimSpace(key)
+	if len(key) < 16 {
+		return nil, fmt.Errorf("share key in %s is shorter than 16 bytes", path)
+	}
+	return key, nil
+}
+
+func setUpShares(ctx context.Context) (*Shares, error) {
+	key, err := loadShareKey(*shareKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &Shares{key: key}
+	s.records, err = ipfsNode.NewCRDT(ctx, "shares", sharesTopic(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.downloads, err = ipfsNode.NewCRDT(ctx, "sharedownloads", shareDownloadsTopic(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Shares) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Token returns the token of a share: its claims and their signature.
+func (s *Shares) Token(share Share) string {
+	data, _ := json.Marshal(shareClaims{
+		ID:           share.ID,
+		CID:          share.CID,
+		ExpiresAt:    share.ExpiresAt.Unix(),
+		MaxDownloads: share.MaxDownloads,
+		Password:     share.Password,
+	})
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.sign(payload)
+}
+
+var errInvalidShareToken = errors.New("invalid share token")
+
+// verify checks the signature of a token and returns its claims.
+func (s *Shares) verify(token string) (shareClaims, error) {
+	var claims shareClaims
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return claims, errInvalidShareToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims, errInvalidShareToken
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return claims, errInvalidShareToken
+	}
+	return claims, nil
+}
+
+// sessionToken returns the token of a download session of a share, which
+// lets the requests resuming a counted download through until it expires.
+func (s *Shares) sessionToken(id string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("session/%s/%d", id, expires.Unix())))
+	return payload + "." + s.sign(payload)
+}
+
+// validSession reports whether a session token was made for a share and
+// has not expired.
+func (s *Shares) validSession(token, id string) bool {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return false
+	}
+	rest, ok := strings.CutPrefix(string(data), "session/"+id+"/")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(rest, 10, 64)
+	return err == nil && time.Now().Unix() < expires
+}
+
+// Create records a new share.
+func (s *Shares) Create(ctx context.Context, share Share, password string) (Share, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return share, err
+	}
+	share.ID = hex.EncodeToString(id)
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return share, err
+		}
+		share.Password = true
+		share.PasswordHash = hash
+	}
+
+	data, err := json.Marshal(share)
+	if err != nil {
+		return share, err
+	}
+	return share, s.records.Put(ctx, share.ID, data)
+}
+
+// Get returns the share of an ID, or datastore.ErrNotFound.
+func (s *Shares) Get(ctx context.Context, id string) (Share, error) {
+	var share Share
+	data, err := s.records.Get(ctx, id)
+	if err != nil {
+		return share, err
+	}
+	err = json.Unmarshal(data, &share)
+	return share, err
+}
+
+// List returns every share, expired or not, newest first.
+func (s *Shares) List(ctx context.Context) ([]Share, error) {
+	values, err := s.records.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]Share, 0, len(values))
+	for _, data := range values {
+		var share Share
+		if err := json.Unmarshal(data, &share); err != nil {
+			continue
+		}
+		shares = append(shares, share)
+	}
+	slices.SortFunc(shares, func(a, b Share) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return shares, nil
+}
+
+// Revoke deletes a share, and with it the validity of its token.
+func (s *Shares) Revoke(ctx context.Context, id string) error {
+	return s.records.Delete(ctx, id)
+}
+
+// Downloads returns the number of downloads of a share on all nodes.
+func (s *Shares) Downloads(ctx context.Context, id string) (int, error) {
+	values, err := s.downloads.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for key, data := range values {
+		if strings.HasPrefix(key, id+"/") {
+			n, _ := strconv.Atoi(string(data))
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// countDownload takes a download of a share if it has any left.
+func (s *Shares) countDownload(ctx context.Context, share Share) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if share.MaxDownloads > 0 {
+		total, err := s.Downloads(ctx, share.ID)
+		if err != nil {
+			return false, err
+		}
+		if total >= share.MaxDownloads {
+			return false, nil
+		}
+	}
+
+	key := share.ID + "/" + ipfsNode.GetHost().ID().String()
+	n := 0
+	if data, err := s.downloads.Get(ctx, key); err == nil {
+		n, _ = strconv.Atoi(string(data))
+	} else if !errors.Is(err, datastore.ErrNotFound) {
+		return false, err
+	}
+	return true, s.downloads.Put(ctx, key, []byte(strconv.Itoa(n+1)))
+}
+
+// link returns the API view of a share, with a URL on the host the request
+// was made to.
+func (s *Shares) link(ctx context.Context, r *http.Request, share Share) ShareLink {
+	downloads, _ := s.Downloads(ctx, share.ID)
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	token := s.Token(share)
+	share.PasswordHash = nil
+	return ShareLink{
+		Share:     share,
+		Downloads: downloads,
+		Token:     token,
+		URL:       fmt.Sprintf("%s://%s/s/%s", scheme, r.Host, token),
+	}
+}
+
+// Create a share link to a file of the catalog. Users can share their own
+// files, admins any file.
+func createShareHandler(w http.ResponseWriter, r *http.Request) {
+	var req ShareRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxShareRequest))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid share request: %s", err.Error()))
+		return
+	}
+
+	c, err := cid.Decode(req.CID)
+	if err != nil {
+		writeErrorDetails(w, http.StatusBadRequest, CodeInvalidCID, "Invalid CID",
+			map[string]string{"cid": req.CID, "reason": err.Error()})
+		return
+	}
+	ttl := defaultShareTTL
+	if req.ExpiresIn != "" {
+		ttl, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 || ttl > maxShareTTL {
+			writeErrorDetails(w, http.StatusUnprocessableEntity, CodeUnprocessable,
+				fmt.Sprintf("expiresIn must be a duration of at most %s", maxShareTTL), map[string]string{"expiresIn": req.ExpiresIn})
+			return
+		}
+	}
+	if req.MaxDownloads < 0 {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, CodeUnprocessable,
+			"maxDownloads cannot be negative", map[string]int{"maxDownloads": req.MaxDownloads})
+		return
+	}
+
+	fileInfo, err := catalog.Get(r.Context(), c.String())
+	if errors.Is(err, datastore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading the catalog")
+		return
+	}
+	principal := principalFrom(r.Context())
+	if fileInfo.Owner != principal.User && !principal.HasRole(RoleAdmin) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Only the owner of a file or an admin can share it")
+		return
+	}
+
+	now := time.Now().UTC()
+	share, err := shares.Create(r.Context(), Share{
+		CID:          fileInfo.CID,
+		Filename:     fileInfo.Filename,
+		CreatedBy:    principal.User,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(ttl).Truncate(time.Second),
+		MaxDownloads: req.MaxDownloads,
+	}, req.Password)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error creating share: %s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, shares.link(r.Context(), r, share))
+}
+
+// List the active share links of the user, or of everyone for admins.
+func getSharesHandler(w http.ResponseWriter, r *http.Request) {
+	all, err := shares.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading shares")
+		return
+	}
+	principal := principalFrom(r.Context())
+	links := []ShareLink{}
+	for _, share := range all {
+		if share.CreatedBy != principal.User && !principal.HasRole(RoleAdmin) {
+			continue
+		}
+		link := shares.link(r.Context(), r, share)
+		if time.Now().After(share.ExpiresAt) || (share.MaxDownloads > 0 && link.Downloads >= share.MaxDownloads) {
+			continue
+		}
+		links = append(links, link)
+	}
+	writeJSON(w, links)
+}
+
+// Revoke a share link. Users can revoke the links they created, admins
+// any link.
+func revokeShareHandler(w http.ResponseWriter, r *http.Request) {
+	share, err := shares.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, datastore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Share not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading shares")
+		return
+	}
+	principal := principalFrom(r.Context())
+	if share.CreatedBy != principal.User && !principal.HasRole(RoleAdmin) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Only the creator of a share or an admin can revoke it")
+		return
+	}
+
+	if err := shares.Revoke(r.Context(), share.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error revoking share: %s", err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Download a shared file. This route needs no API credentials: the token
+// is the credential. Links with a password ask for it with HTTP basic
+// authentication, under any user name.
+func shareDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := shares.verify(r.PathValue("token"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Unknown share link")
+		return
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		writeError(w, http.StatusGone, CodeGone, "Share link has expired")
+		return
+	}
+	share, err := shares.Get(r.Context(), claims.ID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		writeError(w, http.StatusGone, CodeGone, "Share link has been revoked")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading shares")
+		return
+	}
+	if share.CID != claims.CID {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Unknown share link")
+		return
+	}
+
+	if share.Password {
+		_, password, _ := r.BasicAuth()
+		if bcrypt.CompareHashAndPassword(share.PasswordHash, []byte(password)) != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="shared file", charset="UTF-8"`)
+			writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Share link needs a password")
+			return
+		}
+	}
+
+	c, err := cid.Decode(share.CID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	// downloads are counted once the content is found, so that failed
+	// retrievals do not use them up
+	serveFileAdmitted(w, r, c, func() bool { return admitShareDownload(w, r, share) })
+}
+
+// admitShareDownload counts a request as a download of a share, unless it
+// resumes a download session that was already counted: it then carries
+// the cookie of the session and asks for a single range that does not
+// start at the beginning. Every counted request gets a cookie naming a new
+// session, so that resuming its download is not charged again. It writes
+// the error response and returns false when the share has no downloads
+// left.
+func admitShareDownload(w http.ResponseWriter, r *http.Request, share Share) bool {
+	if cookie, err := r.Cookie(shareSessionCookie); err == nil && shares.validSession(cookie.Value, share.ID) && resumesDownload(r) {
+		return true
+	}
+
+	ok, err := shares.countDownload(r.Context(), share)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error counting download: %s", err.Error()))
+		return false
+	}
+	if !ok {
+		writeError(w, http.StatusGone, CodeGone, "Share link has no downloads left")
+		return false
+	}
+
+	expires := time.Now().Add(shareSessionTTL)
+	if expires.After(share.ExpiresAt) {
+		expires = share.ExpiresAt
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareSessionCookie,
+		Value:    shares.sessionToken(share.ID, expires),
+		Path:     r.URL.Path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return true
+}
+
+// resumesDownload reports whether r asks for a single range past the
+// start of the content, as requests resuming a download do.
+func resumesDownload(r *http.Request) bool {
+	spec, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return false
+	}
+	start, _, _ := strings.Cut(spec, "-")
+	n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	return err == nil && n > 0
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ipfslite "ipfs-demo/ipfs"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestNode starts an IPFS node that does not listen for peers and makes
+// it the node of the package.
+func newTestNode(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	key, err := ipfslite.NewIdentity(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := ipfslite.NewInMemoryDatastore()
+	host, dht, err := ipfslite.SetupLibp2p(ctx, key, nil, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { host.Close() })
+	ipfsNode, err = ipfslite.New(ctx, ds, host, dht)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func newTestShares(t *testing.T) context.Context {
+	t.Helper()
+	ctx := newTestNode(t)
+	s := &Shares{key: []byte("0123456789abcdef")}
+	var err error
+	if s.records, err = ipfsNode.NewCRDT(ctx, "shares", "test/shares", nil); err != nil {
+		t.Fatal(err)
+	}
+	if s.downloads, err = ipfsNode.NewCRDT(ctx, "sharedownloads", "test/sharedownloads", nil); err != nil {
+		t.Fatal(err)
+	}
+	shares = s
+	return ctx
+}
+
+func TestAdmitShareDownloadLimit(t *testing.T) {
+	newTestShares(t)
+	share := Share{ID: "limited", CID: "bafy", ExpiresAt: time.Now().Add(time.Hour), MaxDownloads: 1}
+
+	// the first download is counted and opens a session
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/s/token", nil)
+	if !admitShareDownload(w, r, share) {
+		t.Fatalf("first download refused: %d %s", w.Code, w.Body)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != shareSessionCookie {
+		t.Fatalf("first download got cookies %v, want a session", cookies)
+	}
+	session := cookies[0]
+
+	tests := []struct {
+		name    string
+		rng     string
+		session bool
+		want    bool
+	}{
+		{"full download", "", false, false},
+		{"range from start", "bytes=0-", false, false},
+		{"range past start", "bytes=1-", false, false},
+		{"several ranges", "bytes=1-,0-0", false, false},
+		{"resumed in session", "bytes=100-", true, true},
+		{"resumed range in session", "bytes=100-199", true, true},
+		{"full download in session", "", true, false},
+		{"range from start in session", "bytes=0-", true, false},
+		{"several ranges in session", "bytes=100-,0-0", true, false},
+		{"suffix range in session", "bytes=-100", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/s/token", nil)
+			if tt.rng != "" {
+				r.Header.Set("Range", tt.rng)
+			}
+			if tt.session {
+				r.AddCookie(session)
+			}
+			if got := admitShareDownload(w, r, share); got != tt.want {
+				t.Fatalf("admitted = %v, want %v", got, tt.want)
+			}
+			if !tt.want && w.Code != http.StatusGone {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusGone)
+			}
+		})
+	}
+
+	// a session of another share does not let downloads through
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/s/token", nil)
+	r.AddCookie(session)
+	if !admitShareDownload(w, r, Share{ID: "other", ExpiresAt: share.ExpiresAt, MaxDownloads: 1}) {
+		t.Fatal("download of another share refused")
+	}
+	if len(w.Result().Cookies()) != 1 {
+		t.Error("download of another share did not open its own session")
+	}
+}
+
+func TestShareToken(t *testing.T) {
+	newTestShares(t)
+	share := Share{ID: "abc", CID: "bafy", ExpiresAt: time.Unix(2000000000, 0), MaxDownloads: 3, Password: true}
+	token := shares.Token(share)
+	payload, sig, _ := strings.Cut(token, ".")
+
+	forged, _ := json.Marshal(shareClaims{ID: "abc", CID: "bafyother", ExpiresAt: 2000000000})
+	forgedPayload := base64.RawURLEncoding.EncodeToString(forged)
+	other := &Shares{key: []byte("fedcba9876543210")}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", token, true},
+		{"payload swapped", forgedPayload + "." + sig, false},
+		{"signature altered", payload + "." + strings.ToUpper(sig), false},
+		{"signature dropped", payload, false},
+		{"signature empty", payload + ".", false},
+		{"signed with another key", other.Token(share), false},
+		{"session token", shares.sessionToken("abc", share.ExpiresAt), false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := shares.verify(tt.token)
+			if !tt.valid {
+				if !errors.Is(err, errInvalidShareToken) {
+					t.Errorf("error = %v, want %v", err, errInvalidShareToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := shareClaims{ID: "abc", CID: "bafy", ExpiresAt: 2000000000, MaxDownloads: 3, Password: true}
+			if claims != want {
+				t.Errorf("claims = %+v, want %+v", claims, want)
+			}
+		})
+	}
+}
+
+func TestShareSession(t *testing.T) {
+	newTestShares(t)
+	other := &Shares{key: []byte("fedcba9876543210")}
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name  string
+		token string
+		id    string
+		want  bool
+	}{
+		{"valid", shares.sessionToken("abc", later), "abc", true},
+		{"other share", shares.sessionToken("abc", later), "abcd", false},
+		{"prefix of share", shares.sessionToken("ab", later), "abc", false},
+		{"expired", shares.sessionToken("abc", time.Now().Add(-time.Second)), "abc", false},
+		{"signed with another key", other.sessionToken("abc", later), "abc", false},
+		{"share token", shares.Token(Share{ID: "abc", ExpiresAt: later}), "abc", false},
+		{"garbage", "session", "abc", false},
+	}
+	for _, tt := range tests {
+		if got := shares.validSession(tt.token, tt.id); got != tt.want {
+			t.Errorf("%s: validSession = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSharePassword(t *testing.T) {
+	ctx := newTestShares(t)
+	store, err := ipfsNode.NewCRDT(ctx, "catalog", "test/catalog", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog = NewCatalog(store)
+	n, err := ipfsNode.AddFile(ctx, strings.NewReader("shared content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	share, err := shares.Create(ctx, Share{CID: n.Cid().String(), ExpiresAt: time.Now().Add(time.Hour)}, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := shares.Get(ctx, share.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Password || bytes.Contains(stored.PasswordHash, []byte("s3cret")) {
+		t.Fatalf("stored share = %+v, want a password hash", stored)
+	}
+	if err := bcrypt.CompareHashAndPassword(stored.PasswordHash, []byte("s3cret")); err != nil {
+		t.Fatalf("stored hash does not match the password: %s", err)
+	}
+
+	open, err := shares.Create(ctx, Share{CID: n.Cid().String(), ExpiresAt: time.Now().Add(time.Hour)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open.Password || open.PasswordHash != nil {
+		t.Errorf("share without password = %+v, want no hash", open)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		set      bool
+		want     int
+	}{
+		{"no password", "", false, http.StatusUnauthorized},
+		{"empty password", "", true, http.StatusUnauthorized},
+		{"wrong password", "secret", true, http.StatusUnauthorized},
+		{"right password", "s3cret", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/s/token", nil)
+			r.SetPathValue("token", shares.Token(share))
+			if tt.set {
+				r.SetBasicAuth("anyone", tt.password)
+			}
+			shareDownloadHandler(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic ") {
+				t.Errorf("WWW-Authenticate = %q, want a basic challenge", w.Header().Get("WWW-Authenticate"))
+			}
+			if tt.want == http.StatusOK && w.Body.String() != "shared content" {
+				t.Errorf("body = %q, want the shared file", w.Body)
+			}
+		})
+	}
+}
+
+func TestShareDownloadCountedOnRetrieval(t *testing.T) {
+	ctx := newTestShares(t)
+	store, err := ipfsNode.NewCRDT(ctx, "catalog", "test/catalog", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog = NewCatalog(store)
+	found, err := ipfsNode.AddFile(ctx, strings.NewReader("shared content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err := ipfsNode.StageFile(ctx, strings.NewReader("content nobody has"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := staged.Root.Cid()
+	staged.Discard()
+
+	tests := []struct {
+		name   string
+		cid    string
+		status int
+		counts int
+	}{
+		{"content found", found.Cid().String(), http.StatusOK, 1},
+		{"content not found", missing.String(), http.StatusGatewayTimeout, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			share, err := shares.Create(ctx, Share{CID: tt.cid, ExpiresAt: time.Now().Add(time.Hour), MaxDownloads: 1}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/s/token?timeout=200ms", nil)
+			r.SetPathValue("token", shares.Token(share))
+			shareDownloadHandler(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if n, err := shares.Downloads(ctx, share.ID); err != nil || n != tt.counts {
+				t.Errorf("downloads = %d (%v), want %d", n, err, tt.counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// changes, e.g. after a renewal.
+const certReloadInterval = 30 * time.Second
+
+// CertReloader serves a certificate loaded from files and loads it again
+// when the files change, without restarting the server.
+type CertReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	go cr.watch()
+	return cr, nil
+}
+
+// lastModified returns the most recent modification time of the files.
+func (cr *CertReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (cr *CertReloader) reload() error {
+	modTime, err := cr.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.mu.Unlock()
+	return nil
+}
+
+func (cr *CertReloader) watch() {
+	for range time.Tick(certReloadInterval) {
+		modTime, err := cr.lastModified()
+		if err != nil {
+			continue
+		}
+		cr.mu.RLock()
+		changed := modTime.After(cr.modTime)
+		cr.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		// the files may be written one after the other, in which case the
+		// pair does not match yet and is tried again on the next tick
+		if err := cr.reload(); err != nil {
+			fmt.Printf("error reloading TLS certificate: %s\n", err.Error())
+			continue
+		}
+		fmt.Println("reloaded TLS certificate")
+	}
+}
+
+// GetCertificate is the tls.Config callback.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// newTLSConfig builds the server TLS configuration from the flags. With a
+// client CA, clients may present a certificate signed by it to
+// authenticate, and must when -tls-require-client-cert is set.
+func newTLSConfig() (*tls.Config, error) {
+	cr, err := NewCertReloader(*tlsCertFile, *tlsKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.GetCertificate,
+	}
+
+	if *tlsClientCAFile != "" {
+		pem, err := os.ReadFile(*tlsClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client CA: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("loading client CA: no certificate found")
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if *tlsRequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if *tlsRequireClientCert {
+		return nil, errors.New("-tls-require-client-cert needs -tls-client-ca")
+	}
+	return config, nil
+}
+
+// CertAuthenticator accepts the client certificates verified during the TLS
+// handshake. The user is the common name of the certificate, the roles its
+// organizational units and the groups its organizations.
+type CertAuthenticator struct{}
+
+func (CertAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, errNoCredentials
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	return &Principal{
+		User:   subject.CommonName,
+		Roles:  subject.OrganizationalUnit,
+		Groups: subject.Organization,
+	}, nil
+}
+
+// listenAndServe serves handler on addr over TLS when a certificate is
+// configured, and over plain HTTP otherwise. Over TLS, HTTP/2 is negotiated
+// with the clients that support it and websockets are served as wss.
+func listenAndServe(addr string, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if *tlsCertFile == "" {
+		return server.ListenAndServe()
+	}
+
+	config, err := newTLSConfig()
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = config
+	return server.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// pruneInterval is how often old versions are checked against the
+// retention policy, besides after each upload.
+var pruneInterval = time.Hour
+
+// errVersionExists is returned when a concurrent upload recorded the
+// version a new one was numbered as.
+var errVersionExists = errors.New("version already recorded")
+
+// nextVersion returns the version number that follows versions, oldest
+// first.
+func nextVersion(versions []FileInfo) int {
+	if len(versions) == 0 {
+		return 1
+	}
+	return max(versions[len(versions)-1].Version, len(versions)) + 1
+}
+
+// expired returns the versions that the retention policy drops: all but
+// the -versions-keep most recent ones, and those older than
+// -versions-max-age. The latest version is always kept.
+func expired(versions []FileInfo) []FileInfo {
+	var drop []FileInfo
+	for i, fileInfo := range versions[:max(len(versions)-1, 0)] {
+		tooMany := *versionsKeep > 0 && i < len(versions)-*versionsKeep
+		tooOld := *versionsMaxAge > 0 && time.Since(fileInfo.UploadedAt) > *versionsMaxAge
+		if tooMany || tooOld {
+			drop = append(drop, fileInfo)
+		}
+	}
+	return drop
+}
+
+// pruneVersions removes the expired versions of filename that owner
+// uploaded from the catalog, and unpins them. Versions of the filename by
+// other owners are left to their own history.
+func pruneVersions(ctx context.Context, filename, owner string) error {
+	versions, err := catalog.Versions(ctx, filename)
+	if err != nil {
+		return err
+	}
+	versions = slices.DeleteFunc(versions, func(fileInfo FileInfo) bool { return fileInfo.Owner != owner })
+	for _, fileInfo := range expired(versions) {
+		if _, err := removeFile(ctx, fileInfo); err != nil {
+			return err
+		}
+		fmt.Printf("pruned version %d of %s (%s)\n", fileInfo.Version, filename, fileInfo.CID)
+	}
+	return nil
+}
+
+// history is the versions of a filename uploaded by one owner, which the
+// retention policy applies to.
+type history struct {
+	owner    string
+	filename string
+}
+
+// pruneLoop applies the retention policy to the histories whose latest
+// version was uploaded to this node, so that nodes do not prune the same
+// files.
+func pruneLoop(ctx context.Context) {
+	if *versionsKeep <= 0 && *versionsMaxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	self := ipfsNode.GetHost().ID().String()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fileInfos, err := catalog.List(ctx)
+		if err != nil {
+			fmt.Printf("error listing the catalog to prune versions: %s\n", err.Error())
+			continue
+		}
+		latest := make(map[history]FileInfo)
+		for _, fileInfo := range fileInfos {
+			h := history{fileInfo.Owner, fileInfo.Filename}
+			if l, ok := latest[h]; !ok || compareVersions(fileInfo, l) > 0 {
+				latest[h] = fileInfo
+			}
+		}
+		for h, fileInfo := range latest {
+			if fileInfo.Origin != self {
+				continue
+			}
+			if err := pruneVersions(ctx, h.filename, h.owner); err != nil {
+				fmt.Printf("error pruning versions of %s: %s\n", h.filename, err.Error())
+			}
+		}
+	}
+}
+
+// pathVersions returns the versions of the filename named in the request
+// path. It writes the error and returns false when there are none.
+func pathVersions(w http.ResponseWriter, r *http.Request) ([]FileInfo, bool) {
+	filename := r.PathValue("filename")
+	versions, err := catalog.Versions(r.Context(), filename)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading the catalog")
+		return nil, false
+	}
+	if len(versions) == 0 {
+		writeError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("No file named %q", filename))
+		return nil, false
+	}
+	return versions, true
+}
+
+// findVersion returns the index of version v, a number or "latest", in
+// versions. name is the parameter v comes from, for errors.
+func findVersion(w http.ResponseWriter, versions []FileInfo, name, v string) (int, bool) {
+	if v == "" || v == "latest" {
+		return len(versions) - 1, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		invalidParameter(w, name, v)
+		return 0, false
+	}
+	for i, fileInfo := range versions {
+		if fileInfo.Version == n {
+			return i, true
+		}
+	}
+	writeError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("%s has no version %d", versions[0].Filename, n))
+	return 0, false
+}
+
+// List the versions of a filename, oldest first.
+func getVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	if versions, ok := pathVersions(w, r); ok {
+		writeJSON(w, versions)
+	}
+}
+
+// Download a version of a filename, like GET /files/{cid}.
+func getVersionHandler(w http.ResponseWriter, r *http.Request) {
+	versions, ok := pathVersions(w, r)
+	if !ok {
+		return
+	}
+	i, ok := findVersion(w, versions, "version", r.PathValue("version"))
+	if !ok {
+		return
+	}
+	c, err := cid.Decode(versions[i].CID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	serveFile(w, r, c)
+}
+
+// Make an older version the latest one again. It keeps its content and
+// owner and takes the next version number. Only the owner of the version
+// or an admin can restore it.
+func restoreVersionHandler(w http.ResponseWriter, r *http.Request) {
+	versions, ok := pathVersions(w, r)
+	if !ok {
+		return
+	}
+	i, ok := findVersion(w, versions, "version", r.PathValue("version"))
+	if !ok {
+		return
+	}
+	latest := versions[len(versions)-1]
+	principal := principalFrom(r.Context())
+	if versions[i].Owner != principal.User && !principal.HasRole(RoleAdmin) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Only the owner of a version or an admin can restore it")
+		return
+	}
+	if i == len(versions)-1 {
+		writeError(w, http.StatusConflict, CodeConflict, fmt.Sprintf("Version %d is already the latest", latest.Version))
+		return
+	}
+
+	// The restored version is a new entry, so the one it copies stays in
+	// the history
+	fileInfo := versions[i]
+	fileInfo.RestoredFrom = fileInfo.Version
+	fileInfo.Version = nextVersion(versions)
+	fileInfo.Origin = ipfsNode.GetHost().ID().String()
+	fileInfo.UploadedAt = time.Now().UTC()
+	added, err := catalog.Add(r.Context(), fileInfo)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Error updating the catalog: %s", err.Error()))
+		return
+	}
+	if !added {
+		writeError(w, http.StatusConflict, CodeConflict, fmt.Sprintf("Version %d was just recorded by another change", fileInfo.Version))
+		return
+	}
+	broadcastChan <- fileInfo
+	if err := pruneVersions(r.Context(), fileInfo.Filename, fileInfo.Owner); err != nil {
+		fmt.Printf("error pruning versions of %s: %s\n", fileInfo.Filename, err.Error())
+	}
+
+	writeJSON(w, fileInfo)
+}
+
+// VersionRef identifies a version in a VersionDiff.
+type VersionRef struct {
+	Version    int       `json:"version"`
+	CID        string    `json:"cid"`
+	Size       int64     `json:"size"`
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+// VersionDiff compares the sizes of two versions. The byte counts are of
+// the blocks stored for them, so they show how much of the content the
+// versions share.
+type VersionDiff struct {
+	Filename     string     `json:"filename"`
+	From         VersionRef `json:"from"`
+	To           VersionRef `json:"to"`
+	SizeDelta    int64      `json:"sizeDelta"`    // size of To minus size of From
+	SharedBytes  uint64     `json:"sharedBytes"`  // stored once for both versions
+	AddedBytes   uint64     `json:"addedBytes"`   // only in To
+	RemovedBytes uint64     `json:"removedBytes"` // only in From
+}
+
+func versionRef(fileInfo FileInfo) VersionRef {
+	return VersionRef{Version: fileInfo.Version, CID: fileInfo.CID, Size: fileInfo.Size, UploadedAt: fileInfo.UploadedAt}
+}
+
+// Compare the versions given by the from and to query parameters. to
+// defaults to the latest version and from to the one before to.
+func diffVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	versions, ok := pathVersions(w, r)
+	if !ok {
+		return
+	}
+	to, ok := findVersion(w, versions, "to", r.URL.Query().Get("to"))
+	if !ok {
+		return
+	}
+	from := to - 1
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, ok = findVersion(w, versions, "from", v); !ok {
+			return
+		}
+	}
+	if from < 0 {
+		writeError(w, http.StatusUnprocessableEntity, CodeUnprocessable, "There is no earlier version to compare with")
+		return
+	}
+
+	a, b := versions[from], versions[to]
+	diff := VersionDiff{
+		Filename:  a.Filename,
+		From:      versionRef(a),
+		To:        versionRef(b),
+		SizeDelta: b.Size - a.Size,
+	}
+	fromBlocks := accountant.fileBlocks(r.Context(), a)
+	toBlocks := accountant.fileBlocks(r.Context(), b)
+	for k, size := range toBlocks {
+		if _, ok := fromBlocks[k]; ok {
+			diff.SharedBytes += size
+		} else {
+			diff.AddedBytes += size
+		}
+	}
+	for k, size := range fromBlocks {
+		if _, ok := toBlocks[k]; !ok {
+			diff.RemovedBytes += size
+		}
+	}
+	writeJSON(w, diff)
+}
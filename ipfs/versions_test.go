@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// version is a catalog entry of report.pdf in a version test.
+type version struct {
+	owner string
+	n     int
+}
+
+// addVersions records versions of report.pdf, one minute apart, each with
+// content of its own.
+func addVersions(t *testing.T, ctx context.Context, versions []version) {
+	t.Helper()
+	start := time.Now().Add(-time.Hour).UTC()
+	for i, v := range versions {
+		addTestFile(t, ctx, FileInfo{
+			Filename:   "report.pdf",
+			Owner:      v.owner,
+			Version:    v.n,
+			UploadedAt: start.Add(time.Duration(i) * time.Minute),
+		}, fmt.Sprintf("version %d by %s", v.n, v.owner))
+	}
+}
+
+func remainingVersions(t *testing.T, ctx context.Context) []version {
+	t.Helper()
+	fileInfos, err := catalog.Versions(ctx, "report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var remaining []version
+	for _, fileInfo := range fileInfos {
+		remaining = append(remaining, version{fileInfo.Owner, fileInfo.Version})
+	}
+	return remaining
+}
+
+func TestPruneVersions(t *testing.T) {
+	defer func(keep int) { *versionsKeep = keep }(*versionsKeep)
+	*versionsKeep = 1
+
+	tests := []struct {
+		name     string
+		versions []version
+		owner    string
+		want     []version
+	}{
+		{
+			name:     "keeps the latest",
+			versions: []version{{"alice", 1}, {"alice", 2}, {"alice", 3}},
+			owner:    "alice",
+			want:     []version{{"alice", 3}},
+		},
+		{
+			name:     "leaves versions of other owners",
+			versions: []version{{"alice", 1}, {"bob", 2}, {"alice", 3}, {"bob", 4}},
+			owner:    "alice",
+			want:     []version{{"bob", 2}, {"alice", 3}, {"bob", 4}},
+		},
+		{
+			name:     "keeps the latest of the owner under a later version of another",
+			versions: []version{{"alice", 1}, {"bob", 2}},
+			owner:    "bob",
+			want:     []version{{"alice", 1}, {"bob", 2}},
+		},
+		{
+			name:     "nothing of the owner",
+			versions: []version{{"alice", 1}, {"alice", 2}},
+			owner:    "bob",
+			want:     []version{{"alice", 1}, {"alice", 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestCatalog(t)
+			var err error
+			if pinTracker, err = setUpPinTracker(ctx); err != nil {
+				t.Fatal(err)
+			}
+			addVersions(t, ctx, tt.versions)
+
+			if err := pruneVersions(ctx, "report.pdf", tt.owner); err != nil {
+				t.Fatal(err)
+			}
+			if got := remainingVersions(t, ctx); !slices.Equal(got, tt.want) {
+				t.Errorf("versions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	versions := []version{{"alice", 1}, {"bob", 2}, {"alice", 3}}
+	tests := []struct {
+		name    string
+		user    string
+		roles   []string
+		version string
+		status  int
+		want    []version // after the restore
+	}{
+		{"own version", "alice", nil, "1", http.StatusOK, append(versions, version{"alice", 4})},
+		{"version of another owner", "alice", nil, "2", http.StatusForbidden, versions},
+		{"own version under a later one of another", "bob", nil, "2", http.StatusOK, append(versions, version{"bob", 4})},
+		{"admin", "root", []string{RoleAdmin}, "2", http.StatusOK, append(versions, version{"bob", 4})},
+		{"latest", "alice", nil, "latest", http.StatusConflict, versions},
+		{"unknown version", "alice", nil, "7", http.StatusNotFound, versions},
+		{"malformed version", "alice", nil, "first", http.StatusBadRequest, versions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestCatalog(t)
+			addVersions(t, ctx, versions)
+
+			principal := &Principal{User: tt.user, Roles: append([]string{RoleUpload}, tt.roles...)}
+			r := httptest.NewRequest(http.MethodPost, "/versions/report.pdf/"+tt.version+"/restore", nil)
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+			r.SetPathValue("filename", "report.pdf")
+			r.SetPathValue("version", tt.version)
+			w := httptest.NewRecorder()
+			restoreVersionHandler(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if got := remainingVersions(t, ctx); !slices.Equal(got, tt.want) {
+				t.Errorf("versions = %v, want %v", got, tt.want)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var restored FileInfo
+			if err := json.NewDecoder(w.Body).Decode(&restored); err != nil {
+				t.Fatal(err)
+			}
+			from, _ := catalog.Versions(ctx, "report.pdf")
+			if restored.RestoredFrom == 0 || restored.CID != from[restored.RestoredFrom-1].CID {
+				t.Errorf("restored %+v, want a copy of version %s", restored, tt.version)
+			}
+		})
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/net/webdav"
+)
+
+// davFS serves the catalog as a WebDAV file system: a single folder with
+// the latest version of every filename. Reading a file streams it from the
+// node, writing one imports it as the next version of its filename, like
+// an upload.
+type davFS struct{}
+
+// davName returns the filename a WebDAV path refers to, "" for the root.
+// The catalog is flat, so deeper paths do not exist.
+func davName(name string) (string, error) {
+	filename := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if strings.Contains(filename, "/") {
+		return "", davNotExist(name)
+	}
+	return filename, nil
+}
+
+// davNotExist is the error for a missing path. It is an *fs.PathError,
+// which the walk of a PROPFIND skips rather than failing the request.
+func davNotExist(name string) error {
+	return &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// davListing keeps the latest versions read when a PROPFIND lists the
+// root, so that the walk stats every entry from them rather than reading
+// the catalog again for each.
+type davListing struct {
+	latest map[string]FileInfo
+}
+
+type davListingKey struct{}
+
+// latest returns the latest version of filename, or an error that is
+// os.ErrNotExist.
+func (davFS) latest(ctx context.Context, filename string) (FileInfo, error) {
+	if l, _ := ctx.Value(davListingKey{}).(*davListing); l != nil && l.latest != nil {
+		if fileInfo, ok := l.latest[filename]; ok {
+			return fileInfo, nil
+		}
+	}
+	versions, err := catalog.Versions(ctx, filename)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if len(versions) == 0 {
+		return FileInfo{}, davNotExist(filename)
+	}
+	return versions[len(versions)-1], nil
+}
+
+// list returns the latest version of every filename the root holds. Names
+// with a slash, such as the keys of S3 objects, have no path under the
+// flat root and are left out.
+func (davFS) list(ctx context.Context) (map[string]FileInfo, error) {
+	fileInfos, err := catalog.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	latest := latestVersions(fileInfos)
+	for filename := range latest {
+		if filename == "" || strings.Contains(filename, "/") {
+			delete(latest, filename)
+		}
+	}
+	if l, _ := ctx.Value(davListingKey{}).(*davListing); l != nil {
+		l.latest = latest
+	}
+	return latest, nil
+}
+
+// Folders are not supported, the catalog has none.
+func (davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+// OpenFile opens a file without reading its content, which is only looked
+// for on the first read, so that requests that only need its description
+// do not wait for it.
+func (d davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	filename, err := davName(name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		if filename == "" {
+			return nil, os.ErrPermission
+		}
+		return d.create(ctx, filename), nil
+	}
+	if filename == "" {
+		latest, err := d.list(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &davDir{latest: latest}, nil
+	}
+
+	fileInfo, err := d.latest(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cid.Decode(fileInfo.CID)
+	if err != nil {
+		return nil, err
+	}
+	f := &davFile{ctx: ctx, c: c, info: davFileInfo{file: fileInfo}}
+	if fileInfo.Encrypted {
+		if f.key, err = keyStore.Get(c); err != nil {
+			return nil, os.ErrPermission
+		}
+	}
+	return f, nil
+}
+
+// create starts importing filename, with the content written to the
+// returned file. The import completes when the file is closed.
+func (davFS) create(ctx context.Context, filename string) *davUpload {
+	pr, pw := io.Pipe()
+	u := &davUpload{filename: filename, pw: pw, body: davBodyFrom(ctx), done: make(chan struct{})}
+	fileInfo := FileInfo{
+		Filename:  filename,
+		Type:      mime.TypeByExtension(path.Ext(filename)),
+		Encrypted: *encryptUploads,
+	}
+	go func() {
+		defer close(u.done)
+		u.result, u.err = importFile(ctx, principalFrom(ctx), fileInfo, pr)
+		// unblock writes if the import failed before reading everything
+		pr.CloseWithError(u.err)
+	}()
+	return u
+}
+
+// RemoveAll removes the versions of a file that the principal owns, or
+// every version for admins.
+func (d davFS) RemoveAll(ctx context.Context, name string) error {
+	filename, err := davName(name)
+	if err != nil {
+		return err
+	}
+	if filename == "" {
+		return os.ErrPermission
+	}
+	versions, err := d.owned(ctx, filename)
+	if err != nil {
+		return err
+	}
+	for _, fileInfo := range versions {
+		if _, err := removeFile(ctx, fileInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename gives the versions of a file that the principal owns the new
+// name, or every version for admins.
+func (d davFS) Rename(ctx context.Context, oldName, newName string) error {
+	from, err := davName(oldName)
+	if err != nil {
+		return err
+	}
+	to, err := davName(newName)
+	if err != nil {
+		return os.ErrPermission
+	}
+	if from == "" || to == "" {
+		return os.ErrPermission
+	}
+	if _, err := d.latest(ctx, to); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	versions, err := d.owned(ctx, from)
+	if err != nil {
+		return err
+	}
+	// entries are keyed by filename, so each version moves to a new one
+	for _, fileInfo := range versions {
+		renamed := fileInfo
+		renamed.Filename = to
+		if err := catalog.Update(ctx, renamed); err != nil {
+			return err
+		}
+		if err := catalog.Remove(ctx, fileInfo); err != nil {
+			return err
+		}
+		broadcastChan <- renamed
+	}
+	return nil
+}
+
+// owned returns the versions of filename that the principal of ctx may
+// change, and os.ErrPermission when there are none.
+func (d davFS) owned(ctx context.Context, filename string) ([]FileInfo, error) {
+	versions, err := catalog.Versions(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, os.ErrNotExist
+	}
+	principal := principalFrom(ctx)
+	if !principal.HasRole(RoleAdmin) {
+		versions = slices.DeleteFunc(versions, func(fileInfo FileInfo) bool { return fileInfo.Owner != principal.User })
+	}
+	if len(versions) == 0 {
+		return nil, os.ErrPermission
+	}
+	return versions, nil
+}
+
+func (d davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	filename, err := davName(name)
+	if err != nil {
+		return nil, err
+	}
+	if filename == "" {
+		return davFileInfo{dir: true}, nil
+	}
+	fileInfo, err := d.latest(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	return davFileInfo{file: fileInfo}, nil
+}
+
+// davFileInfo describes the root folder or a catalog entry. It gives the
+// CID as ETag and the catalog type as content type, so that clients do not
+// need to read files to list them.
+type davFileInfo struct {
+	file FileInfo
+	dir  bool
+}
+
+func (i davFileInfo) Name() string {
+	if i.dir {
+		return "/"
+	}
+	return i.file.Filename
+}
+
+func (i davFileInfo) Size() int64 { return i.file.Size }
+
+func (i davFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (i davFileInfo) ModTime() time.Time { return i.file.UploadedAt }
+func (i davFileInfo) IsDir() bool        { return i.dir }
+func (i davFileInfo) Sys() any           { return nil }
+
+func (i davFileInfo) ETag(ctx context.Context) (string, error) {
+	if i.file.CID == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fmt.Sprintf("%q", i.file.CID), nil
+}
+
+func (i davFileInfo) ContentType(ctx context.Context) (string, error) {
+	if i.file.Type == "" || i.file.Type == "application/octet-stream" {
+		// let the handler guess from the extension or the content
+		return "", webdav.ErrNotImplemented
+	}
+	return i.file.Type, nil
+}
+
+// davDir is the root folder opened for listing.
+type davDir struct {
+	latest map[string]FileInfo
+	read   bool
+}
+
+func (d *davDir) Close() error                                 { return nil }
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, fs.ErrInvalid }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, fs.ErrInvalid }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, fs.ErrPermission }
+func (d *davDir) Stat() (fs.FileInfo, error)                   { return davFileInfo{dir: true}, nil }
+
+func (d *davDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if d.read {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	d.read = true
+	infos := make([]fs.FileInfo, 0, len(d.latest))
+	for _, fileInfo := range d.latest {
+		infos = append(infos, davFileInfo{file: fileInfo})
+	}
+	return infos, nil
+}
+
+// davFile is a catalog entry opened for reading. Seeking only moves the
+// offset until the content is opened by the first read.
+type davFile struct {
+	ctx  context.Context
+	c    cid.Cid
+	key  []byte // of encrypted files
+	info davFileInfo
+
+	rsc    io.ReadSeekCloser
+	offset int64
+}
+
+// open finds the content, giving up after the retrieval timeout like
+// downloads through /files, and seeks to the offset.
+func (f *davFile) open() error {
+	if *retrievalTimeout > 0 {
+		tctx, cancel := context.WithTimeout(f.ctx, *retrievalTimeout)
+		_, err := ipfsNode.Get(tctx, f.c)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	var rsc io.ReadSeekCloser
+	var err error
+	if f.key != nil {
+		rsc, err = ipfsNode.GetEncryptedFile(f.ctx, f.c, f.key)
+	} else {
+		rsc, err = ipfsNode.GetFile(f.ctx, f.c)
+	}
+	if err != nil {
+		return err
+	}
+	if f.offset != 0 {
+		if _, err := rsc.Seek(f.offset, io.SeekStart); err != nil {
+			rsc.Close()
+			return err
+		}
+	}
+	f.rsc = rsc
+	return nil
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if f.rsc == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.rsc.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return f.offset, fs.ErrInvalid
+	}
+	if f.rsc != nil {
+		if _, err := f.rsc.Seek(offset, io.SeekStart); err != nil {
+			return f.offset, err
+		}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *davFile) Close() error {
+	if f.rsc == nil {
+		return nil
+	}
+	return f.rsc.Close()
+}
+
+func (f *davFile) Write(p []byte) (int, error)              { return 0, fs.ErrPermission }
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) { return nil, fs.ErrInvalid }
+func (f *davFile) Stat() (fs.FileInfo, error)               { return f.info, nil }
+
+// davUpload is a file opened for writing. Its content is imported as it is
+// written.
+type davUpload struct {
+	filename string
+	pw       *io.PipeWriter
+	body     *davBody
+	written  int64
+
+	done   chan struct{}
+	result FileInfo
+	err    error
+}
+
+func (u *davUpload) Write(p []byte) (int, error) {
+	n, err := u.pw.Write(p)
+	u.written += int64(n)
+	return n, err
+}
+
+// Close ends the content and waits for the import. When the request body
+// could not be read entirely the import is aborted rather than keeping a
+// truncated file.
+func (u *davUpload) Close() error {
+	if u.body != nil && u.body.err != nil {
+		u.pw.CloseWithError(u.body.err)
+	} else {
+		u.pw.Close()
+	}
+	<-u.done
+	return u.err
+}
+
+func (u *davUpload) Read(p []byte) (int, error)                   { return 0, fs.ErrPermission }
+func (u *davUpload) Seek(offset int64, whence int) (int64, error) { return 0, fs.ErrInvalid }
+func (u *davUpload) Readdir(count int) ([]fs.FileInfo, error)     { return nil, fs.ErrInvalid }
+
+func (u *davUpload) Stat() (fs.FileInfo, error) {
+	return &davUploadInfo{davFileInfo{file: FileInfo{Filename: u.filename, Size: u.written, UploadedAt: time.Now().UTC()}}, u}, nil
+}
+
+// davUploadInfo describes a file being written. Its ETag is the CID once
+// the import is done.
+type davUploadInfo struct {
+	davFileInfo
+	u *davUpload
+}
+
+func (i *davUploadInfo) ETag(ctx context.Context) (string, error) {
+	select {
+	case <-i.u.done:
+		if i.u.err == nil {
+			return fmt.Sprintf("%q", i.u.result.CID), nil
+		}
+	default:
+	}
+	return "", webdav.ErrNotImplemented
+}
+
+// davBody records the error reading a request body, which the webdav
+// handler does not pass on to the file system.
+type davBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *davBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+type davBodyKey struct{}
+
+func davBodyFrom(ctx context.Context) *davBody {
+	b, _ := ctx.Value(davBodyKey{}).(*davBody)
+	return b
+}
+
+// davHandler serves the catalog over WebDAV under /dav/. Reads need the
+// read role and changes the upload role. Clients that only speak basic
+// authentication give their API token as password. limit applies the rate
+// limit of each kind of request.
+func davHandler(limit func(class string, next http.HandlerFunc) http.HandlerFunc) http.HandlerFunc {
+	h := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: davFS{},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				fmt.Printf("webdav %s %s: %s\n", r.Method, r.URL.Path, err.Error())
+			}
+		},
+	}
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			body := &davBody{ReadCloser: r.Body}
+			r.Body = body
+			r = r.WithContext(context.WithValue(r.Context(), davBodyKey{}, body))
+		case "PROPFIND":
+			r = r.WithContext(context.WithValue(r.Context(), davListingKey{}, &davListing{}))
+		}
+		h.ServeHTTP(w, r)
+	}
+	// Ask for basic credentials, which OS clients prompt for
+	challenge := func(role string) http.HandlerFunc {
+		next := requireRole(role, serve)
+		return func(w http.ResponseWriter, r *http.Request) {
+			if _, err := authenticate(r); err != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="ipfs-demo"`)
+				writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Missing or invalid credentials")
+				return
+			}
+			next(w, r)
+		}
+	}
+
+	download := limit("download", challenge(RoleRead))
+	upload := limit("upload", challenge(RoleUpload))
+	read := limit("api", challenge(RoleRead))
+	change := limit("api", challenge(RoleUpload))
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			download(w, r)
+		case http.MethodPut:
+			upload(w, r)
+		case http.MethodDelete, "MKCOL", "COPY", "MOVE", "PROPPATCH", "LOCK", "UNLOCK":
+			change(w, r)
+		default:
+			read(w, r)
+		}
+	}
+}
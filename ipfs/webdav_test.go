@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestDavOwnership(t *testing.T) {
+	versions := []version{{"alice", 1}, {"bob", 2}, {"alice", 3}}
+	tests := []struct {
+		name   string
+		user   string
+		roles  []string
+		rename bool
+		err    error
+		left   []version // of report.pdf
+		moved  []version // to notes.pdf
+	}{
+		{"remove own versions", "alice", nil, false, nil, []version{{"bob", 2}}, nil},
+		{"remove owned under the latest of another", "bob", nil, false, nil, []version{{"alice", 1}, {"alice", 3}}, nil},
+		{"remove without owning any", "carol", nil, false, os.ErrPermission, versions, nil},
+		{"remove as admin", "root", []string{RoleAdmin}, false, nil, nil, nil},
+		{"rename own versions", "alice", nil, true, nil, []version{{"bob", 2}}, []version{{"alice", 1}, {"alice", 3}}},
+		{"rename without owning any", "carol", nil, true, os.ErrPermission, versions, nil},
+		{"rename as admin", "root", []string{RoleAdmin}, true, nil, nil, versions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestCatalog(t)
+			var err error
+			if pinTracker, err = setUpPinTracker(ctx); err != nil {
+				t.Fatal(err)
+			}
+			addVersions(t, ctx, versions)
+
+			principal := &Principal{User: tt.user, Roles: append([]string{RoleUpload}, tt.roles...)}
+			ctx = context.WithValue(ctx, principalKey{}, principal)
+			if tt.rename {
+				err = davFS{}.Rename(ctx, "/report.pdf", "/notes.pdf")
+			} else {
+				err = davFS{}.RemoveAll(ctx, "/report.pdf")
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+
+			if got := remainingVersions(t, ctx); !slices.Equal(got, tt.left) {
+				t.Errorf("versions left = %v, want %v", got, tt.left)
+			}
+			moved, err := catalog.Versions(ctx, "notes.pdf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []version
+			for _, fileInfo := range moved {
+				got = append(got, version{fileInfo.Owner, fileInfo.Version})
+			}
+			if !slices.Equal(got, tt.moved) {
+				t.Errorf("versions renamed = %v, want %v", got, tt.moved)
+			}
+		})
+	}
+}
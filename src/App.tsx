@@ -20,9 +20,14 @@ type UploadedFile = {
     size: number;
     type: string;
     cid: string;
+    version?: number;
 };
 
 const serverIPv4 = "18.141.55.147"
+// Pages served over https may only reach the API over https and wss
+const secure = window.location.protocol === "https:"
+const apiURL = (secure ? "https://" : "http://") + serverIPv4 + ":8000"
+const socketURL = (secure ? "wss://" : "ws://") + serverIPv4 + ":8000"
 
 export default function Component() {
     const [isUploading, setIsUploading] = useState(false);
@@ -35,11 +40,11 @@ export default function Component() {
         const fetchData = async () => {
             try {
 
-                const response = await fetch("http://" + serverIPv4 + ":8000/files");
+                const response = await fetch(apiURL + "/files?limit=1000");
                 if (response.ok) {
                     try {
                         const data = await response.json();
-                        if (data != null || data.length !== 0) setUploadedFiles(data);
+                        if (data != null && data.files != null) setUploadedFiles(data.files);
                     } catch (_) {}
                 } else {
                     toast({
@@ -56,7 +61,7 @@ export default function Component() {
     }, []);
 
     useEffect(() => {
-        webSocket.current = new WebSocket("ws://" + serverIPv4 + ":8000/socket");
+        webSocket.current = new WebSocket(socketURL + "/socket");
 
         webSocket.current.onopen = () => {
             console.log("WebSocket connection established.");
@@ -65,15 +70,30 @@ export default function Component() {
         webSocket.current.onmessage = (event) => {
             try {
                 const message = JSON.parse(event.data);
-                setUploadedFiles((prevFiles) => [
-                    ...prevFiles,
-                    {
-                        filename: message.filename,
-                        size: message.size,
-                        type: message.type,
-                        cid: message.cid,
-                    },
-                ]);
+                if (message.event === "deleted") {
+                    setUploadedFiles((prevFiles) =>
+                        prevFiles.filter((file) => file.cid !== message.cid)
+                    );
+                    return;
+                }
+                const uploadedFile = {
+                    filename: message.filename,
+                    size: message.size,
+                    type: message.type,
+                    cid: message.cid,
+                    version: message.version,
+                };
+                // Edited files are sent again, and new or restored versions
+                // of a filename take the place of the one shown
+                const sameName = (file: UploadedFile) => file.filename === uploadedFile.filename;
+                const newer = (file: UploadedFile) => sameName(file) && (file.version ?? 0) > (uploadedFile.version ?? 0);
+                const replaces = (file: UploadedFile) => file.cid === uploadedFile.cid || (sameName(file) && !newer(file));
+                setUploadedFiles((prevFiles) => {
+                    if (prevFiles.some(newer)) return prevFiles;
+                    return prevFiles.some(replaces)
+                        ? prevFiles.map((file) => (replaces(file) ? uploadedFile : file))
+                        : [...prevFiles, uploadedFile];
+                });
             } catch (error) {
                 console.error("Failed to parse message:", error);
             }
@@ -169,7 +189,7 @@ export default function Component() {
 
         try {
             setIsUploading(true);
-            const response = await fetch("http://" + serverIPv4 + ":8000/upload", {
+            const response = await fetch(apiURL + "/upload", {
                 method: "POST",
                 body: formData,
             });
@@ -294,7 +314,7 @@ export default function Component() {
                                     size="icon"
                                     onClick={() => {}}
                                 >
-                                    <a href={`http://${serverIPv4}:8080${file.cid}`} target="_blank"><Download className="h-5 w-5"/></a>
+                                    <a href={`${apiURL}/files/${file.cid}`} target="_blank" rel="noreferrer"><Download className="h-5 w-5"/></a>
                                     <span className="sr-only">Download</span>
                                 </Button>
                             </li>